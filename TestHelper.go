@@ -9,11 +9,71 @@ package cmemory
 
 /*
 #include <mcheck.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+#include <wchar.h>
+
+#include "ring.h"
+
+typedef struct
+{
+	char tag;
+	int32_t id;
+	double value;
+	char name[10];
+	short port;
+	struct
+	{
+		uint8_t a;
+		uint64_t b;
+	} inner;
+	void* ptr;
+} test_struct;
+
+typedef struct __attribute__((packed))
+{
+	char a;
+	int32_t b;
+	short c;
+} test_packed;
+
+static void fill_test_struct(test_struct* s)
+{
+	s->tag = 'x';
+	s->id = -42;
+	s->value = 2.5;
+	strcpy(s->name, "gopher");
+	s->port = 8080;
+	s->inner.a = 7;
+	s->inner.b = 1ULL << 40;
+	s->ptr = s;
+}
+static void atomic_add_loop(uint64_t* counter, int count)
+{
+	for(int i = 0; i < count; i++)
+	{
+		__atomic_fetch_add(counter, 1, __ATOMIC_SEQ_CST);
+	}
+}
+static void ring_produce(struct ring_header* ring, uint64_t count)
+{
+	for(uint64_t i = 0; i < count; i++)
+	{
+		while(ring_try_write(ring, &i, sizeof(i)) == RING_FULL)
+		{
+		}
+	}
+}
 #cgo LDFLAGS: -lmcheck
 */
 import "C"
 
-import "unsafe"
+import (
+	"time"
+	"unsafe"
+)
 
 // Checks if the memory block is allocated and consistent, according to glibc's
 // memory debugging.
@@ -26,3 +86,63 @@ func isConsistent(buf unsafe.Pointer) bool {
 func testMalloc(size uint64) unsafe.Pointer {
 	return C.malloc(C.size_t(size))
 }
+
+// Calls C's free() directly, without going through a Memory.
+func testFree(ptr unsafe.Pointer) {
+	C.free(ptr)
+}
+
+// Calls C's strlen() on a NUL-terminated string.
+func testStrlen(str unsafe.Pointer) uint64 {
+	return uint64(C.strlen((*C.char)(str)))
+}
+
+// Calls C's wcslen() on a NUL-terminated wide string.
+func testWcslen(str unsafe.Pointer) uint64 {
+	return uint64(C.wcslen((*C.wchar_t)(str)))
+}
+
+// Returns the size of test_struct followed by the offsets of its fields after
+// the first.
+func testStructLayout() []uint64 {
+	return []uint64{C.sizeof_test_struct, uint64(unsafe.Offsetof(C.test_struct{}.id)), uint64(unsafe.Offsetof(C.test_struct{}.value)), uint64(unsafe.Offsetof(C.test_struct{}.name)), uint64(unsafe.Offsetof(C.test_struct{}.port)), uint64(unsafe.Offsetof(C.test_struct{}.inner)), uint64(unsafe.Offsetof(C.test_struct{}.ptr))}
+}
+
+// Returns the size of the packed test_packed struct.
+func testPackedSize() uint64 {
+	return C.sizeof_test_packed
+}
+
+// Fills in a test_struct from C.
+func testFillStruct(s unsafe.Pointer) {
+	C.fill_test_struct((*C.test_struct)(s))
+}
+
+// Reads the id and port fields of a test_struct from C.
+func testStructFields(s unsafe.Pointer) (int32, int16) {
+	return int32((*C.test_struct)(s).id), int16((*C.test_struct)(s).port)
+}
+
+// Atomically increments a 64-bit counter count times from C.
+func testAtomicAddLoop(counter unsafe.Pointer, count int) {
+	C.atomic_add_loop((*C.uint64_t)(counter), C.int(count))
+}
+
+// Claims length bytes of a ring buffer without ever publishing them, like a
+// producer that died in the middle of a write.
+func testRingReserve(ring unsafe.Pointer, length uint64) {
+	var start C.uint64_t
+	data := make([]byte, length)
+	C.ring_reserve((*C.struct_ring_header)(ring), unsafe.Pointer(&data[0]), C.size_t(length), &start)
+}
+
+// Writes to a ring buffer from C, waiting timeout for earlier producers, and
+// returns whether ring_write_timeout returned RING_STALLED.
+func testRingWriteStalled(ring unsafe.Pointer, data []byte, timeout time.Duration) bool {
+	return C.ring_write_timeout((*C.struct_ring_header)(ring), unsafe.Pointer(&data[0]), C.size_t(len(data)), C.uint64_t(timeout)) == C.RING_STALLED
+}
+
+// Writes the numbers from 0 to count-1 to a ring buffer from C.
+func testRingProduce(ring unsafe.Pointer, count uint64) {
+	C.ring_produce((*C.struct_ring_header)(ring), C.uint64_t(count))
+}
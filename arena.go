@@ -0,0 +1,124 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"errors"
+	"unsafe"
+)
+
+var ErrInvalidAlignment = errors.New("Alignment must be a power of two")
+
+// DefaultAlignment is the alignment used when an alignment of 0 is requested.
+// It matches what malloc() guarantees on common 64-bit platforms.
+const DefaultAlignment = 16
+
+// Arena carves many small allocations out of a few large C blocks so that they
+// can all be freed at once. Blocks are chained instead of grown, so pointers
+// handed out by an Arena stay valid until Reset() or Close(). An Arena is not
+// safe for concurrent use.
+type Arena struct {
+	blockSize uint64
+	blocks    []*Memory
+	offset    uint64
+}
+
+// NewArena creates an Arena that allocates C blocks of blockSize bytes. Larger
+// allocations get a block of their own.
+func NewArena(blockSize uint64) *Arena {
+	return &Arena{blockSize: blockSize}
+}
+
+func validAlignment(align uint64) bool {
+	return align != 0 && align&(align-1) == 0
+}
+
+// alignUp rounds address up to the next multiple of align, which must be a
+// power of two.
+func alignUp(address uintptr, align uint64) uintptr {
+	return (address + uintptr(align) - 1) &^ (uintptr(align) - 1)
+}
+
+// AllocPointer returns a pointer to size bytes aligned to align. An alignment
+// of 0 uses DefaultAlignment.
+func (this *Arena) AllocPointer(size uint64, align uint64) (unsafe.Pointer, error) {
+	if align == 0 {
+		align = DefaultAlignment
+	}
+	if !validAlignment(align) {
+		return nil, ErrInvalidAlignment
+	}
+	if len(this.blocks) > 0 {
+		if ptr := this.carve(size, align); ptr != nil {
+			return ptr, nil
+		}
+	}
+	newSize := this.blockSize
+	if size+align-1 > newSize {
+		newSize = size + align - 1
+	}
+	newBlock, err := Alloc(newSize)
+	if err != nil {
+		return nil, err
+	}
+	this.blocks = append(this.blocks, newBlock)
+	this.offset = 0
+	return this.carve(size, align), nil
+}
+
+// carve takes size bytes from the current block, or returns nil if they don't
+// fit.
+func (this *Arena) carve(size uint64, align uint64) unsafe.Pointer {
+	current := this.blocks[len(this.blocks)-1]
+	start := uintptr(current.Cbuf)
+	address := alignUp(start+uintptr(this.offset), align)
+	end := uint64(address-start) + size
+	if end > current.Size {
+		return nil
+	}
+	this.offset = end
+	return unsafe.Add(current.Cbuf, address-start)
+}
+
+// Alloc returns a Memory covering size bytes aligned to align. The Memory is a
+// section of one of the Arena's blocks: Close() doesn't free it, Grow() fails,
+// and it keeps the block from being finalized while it is in use. It must not
+// be used after the Arena is reset, and returns ErrClosed once the Arena is
+// closed.
+func (this *Arena) Alloc(size uint64, align uint64) (*Memory, error) {
+	ptr, err := this.AllocPointer(size, align)
+	if err != nil {
+		return nil, err
+	}
+	current := this.blocks[len(this.blocks)-1]
+	return current.Section(uint64(uintptr(ptr)-uintptr(current.Cbuf)), size)
+}
+
+// Reset frees every block except the first, which is kept for reuse. All
+// previously returned allocations become invalid.
+func (this *Arena) Reset() {
+	if len(this.blocks) == 0 {
+		return
+	}
+	for _, block := range this.blocks[1:] {
+		block.Close()
+	}
+	this.blocks = this.blocks[:1]
+	this.offset = 0
+}
+
+// Close implements the io.Closer interface to free all of the Arena's blocks.
+// The Arena can still be used afterwards and will allocate new blocks.
+func (this *Arena) Close() error {
+	for _, block := range this.blocks {
+		block.Close()
+	}
+	this.blocks = nil
+	this.offset = 0
+	return nil
+}
+
+// Blocks returns the number of C blocks currently held by the Arena.
+func (this *Arena) Blocks() int {
+	return len(this.blocks)
+}
@@ -0,0 +1,124 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestArenaAlloc(t *testing.T) {
+	arena := NewArena(256)
+	defer arena.Close()
+	var previous uintptr
+	for i := 0; i < 8; i++ {
+		ptr, err := arena.AllocPointer(24, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uintptr(ptr)%DefaultAlignment != 0 {
+			t.Error("AllocPointer() returned a misaligned pointer")
+		}
+		if previous != 0 && uintptr(ptr) == previous {
+			t.Error("AllocPointer() returned the same pointer twice")
+		}
+		previous = uintptr(ptr)
+	}
+	if arena.Blocks() != 1 {
+		t.Error("AllocPointer() allocated more blocks than needed")
+	}
+	ptr, err := arena.AllocPointer(100, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uintptr(ptr)%64 != 0 {
+		t.Error("AllocPointer() ignored the requested alignment")
+	}
+	_, err = arena.AllocPointer(8, 3)
+	if err != ErrInvalidAlignment {
+		t.Error("AllocPointer() accepted an invalid alignment")
+	}
+}
+
+func TestArenaChaining(t *testing.T) {
+	arena := NewArena(64)
+	defer arena.Close()
+	first, _ := arena.AllocPointer(48, 0)
+	*(*uint64)(first) = 0xdeadbeef
+	_, err := arena.AllocPointer(48, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arena.Blocks() != 2 {
+		t.Error("AllocPointer() failed to chain a new block")
+	}
+	if *(*uint64)(first) != 0xdeadbeef {
+		t.Error("Chaining a block moved an earlier allocation")
+	}
+	big, err := arena.AllocPointer(1024, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if big == nil || arena.Blocks() != 3 {
+		t.Error("AllocPointer() failed to allocate a block larger than the block size")
+	}
+}
+
+func TestArenaMemory(t *testing.T) {
+	arena := NewArena(256)
+	mem, err := arena.Alloc(32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Size != 32 {
+		t.Error("Alloc() set the wrong size")
+	}
+	if mem.Grow(64) != ErrNotOwner {
+		t.Error("Grow() moved a block borrowed from an arena")
+	}
+	mem.Close()
+	// The arena still owns the block, so this must not be a double free.
+	arena.Close()
+}
+
+func TestArenaKeepsBlock(t *testing.T) {
+	view, _ := NewArena(1<<20).Alloc(64, 0)
+	copy(view.Bytes(), "arena")
+	runtime.GC()
+	runtime.GC()
+	data := make([]byte, 5)
+	if _, err := view.Read(data); err != nil || string(data) != "arena" {
+		t.Error("Arena block was freed while a view of it was in use")
+	}
+	view.Close()
+	arena := NewArena(256)
+	view, _ = arena.Alloc(8, 0)
+	arena.Close()
+	if _, err := view.ReadByte(); err != ErrClosed {
+		t.Error("View still usable after its arena was closed")
+	}
+}
+
+func TestArenaReset(t *testing.T) {
+	arena := NewArena(64)
+	first, _ := arena.AllocPointer(48, 0)
+	arena.AllocPointer(48, 0)
+	arena.AllocPointer(48, 0)
+	arena.Reset()
+	if arena.Blocks() != 1 {
+		t.Error("Reset() did not free the chained blocks")
+	}
+	again, _ := arena.AllocPointer(48, 0)
+	if again != first {
+		t.Error("Reset() did not rewind the first block")
+	}
+	arena.Close()
+	if arena.Blocks() != 0 {
+		t.Error("Close() did not free all blocks")
+	}
+	ptr, err := arena.AllocPointer(8, 0)
+	if err != nil || ptr == nil {
+		t.Error("Arena could not be reused after Close()")
+	}
+	arena.Close()
+}
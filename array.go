@@ -0,0 +1,185 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"errors"
+	"iter"
+	"reflect"
+	"unsafe"
+)
+
+var ErrInvalidElement = errors.New("Element type must be pointer-free and non-empty")
+var ErrMisaligned = errors.New("Memory block is not aligned for this access")
+
+// Array is a typed view of a Memory as consecutive elements of type T. The
+// elements live outside of the Go heap, so the garbage collector never scans
+// them, and the block can still be passed to C. T must not contain pointers,
+// strings, slices, maps, channels, functions, or interfaces.
+type Array[T any] struct {
+	mem      *Memory
+	length   uint64
+	elemSize uint64
+}
+
+// pointerFree returns whether values of a type can be stored in C memory
+// without hiding Go pointers from the garbage collector.
+func pointerFree(elemType reflect.Type) bool {
+	switch elemType.Kind() {
+	case reflect.Array:
+		return pointerFree(elemType.Elem())
+	case reflect.Struct:
+		for i := 0; i < elemType.NumField(); i++ {
+			if !pointerFree(elemType.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface, reflect.Slice, reflect.String:
+		return false
+	}
+	return true
+}
+
+// NewArray creates an Array over all of mem. Its length is the number of whole
+// elements that fit in mem.Size. The block must be aligned for T.
+func NewArray[T any](mem *Memory) (*Array[T], error) {
+	var zero T
+	elemType := reflect.TypeOf(&zero).Elem()
+	if elemType.Size() == 0 || !pointerFree(elemType) {
+		return nil, ErrInvalidElement
+	}
+	if mem.isClosed() {
+		return nil, ErrClosed
+	}
+	if uintptr(mem.Cbuf)%uintptr(elemType.Align()) != 0 {
+		return nil, ErrMisaligned
+	}
+	elemSize := uint64(elemType.Size())
+	return &Array[T]{mem, mem.Size / elemSize, elemSize}, nil
+}
+
+// AllocArray creates an Array of length zeroed elements in a new C block.
+func AllocArray[T any](length uint64) (*Array[T], error) {
+	var zero T
+	mem, err := Alloc(length * uint64(unsafe.Sizeof(zero)))
+	if err != nil {
+		return nil, err
+	}
+	clear(mem.Bytes())
+	array, err := NewArray[T](mem)
+	if err != nil {
+		mem.Close()
+		return nil, err
+	}
+	return array, nil
+}
+
+// Len returns the number of elements in the array.
+func (this *Array[T]) Len() uint64 {
+	return this.clamp()
+}
+
+// clamp shortens the array if its block was shrunk through Memory(), and
+// returns the length.
+func (this *Array[T]) clamp() uint64 {
+	if capacity := this.Cap(); this.length > capacity {
+		this.length = capacity
+	}
+	return this.length
+}
+
+// Cap returns the number of elements that fit in the block before Append has
+// to grow it.
+func (this *Array[T]) Cap() uint64 {
+	return this.mem.Size / this.elemSize
+}
+
+// element returns a pointer to element i.
+func (this *Array[T]) element(i uint64) *T {
+	return (*T)(unsafe.Add(this.mem.Cbuf, i*this.elemSize))
+}
+
+// At returns element i, or ErrOutOfRange if i is past the end.
+func (this *Array[T]) At(i uint64) (T, error) {
+	var zero T
+	if err := this.mem.readable(); err != nil {
+		return zero, err
+	}
+	if i >= this.clamp() {
+		return zero, ErrOutOfRange
+	}
+	return *this.element(i), nil
+}
+
+// Set stores v as element i, or returns ErrOutOfRange if i is past the end.
+func (this *Array[T]) Set(i uint64, v T) error {
+	if err := this.mem.writable(); err != nil {
+		return err
+	}
+	if i >= this.clamp() {
+		return ErrOutOfRange
+	}
+	*this.element(i) = v
+	return nil
+}
+
+// Append adds v to the end of the array, growing the block with Grow() by
+// doubling when it is full. Growing can move the block, so pointers into it
+// from Pointer() and Slice() must be fetched again.
+func (this *Array[T]) Append(v T) error {
+	if err := this.mem.writable(); err != nil {
+		return err
+	}
+	if this.clamp() == this.Cap() {
+		newCap := this.length * 2
+		if newCap == 0 {
+			newCap = 1
+		}
+		err := this.mem.Grow(newCap * this.elemSize)
+		if err != nil {
+			return err
+		}
+	}
+	*this.element(this.length) = v
+	this.length += 1
+	return nil
+}
+
+// All returns an iterator over the indices and values of the elements.
+func (this *Array[T]) All() iter.Seq2[uint64, T] {
+	return func(yield func(uint64, T) bool) {
+		for i := uint64(0); this.mem.readable() == nil && i < this.clamp(); i++ {
+			if !yield(i, *this.element(i)) {
+				return
+			}
+		}
+	}
+}
+
+// Slice returns the elements as a Go slice backed by the C block, without
+// copying them. It is only valid until the next Append, Grow(), or Close().
+func (this *Array[T]) Slice() []T {
+	if this.mem.readable() != nil || this.clamp() == 0 {
+		return nil
+	}
+	return unsafe.Slice(this.element(0), this.length)
+}
+
+// Pointer returns the address of the first element, for passing to C.
+func (this *Array[T]) Pointer() unsafe.Pointer {
+	if this.mem.isClosed() {
+		return nil
+	}
+	return this.mem.Cbuf
+}
+
+// Memory returns the Memory that holds the elements.
+func (this *Array[T]) Memory() *Memory {
+	return this.mem
+}
+
+// Close implements the io.Closer interface to close the underlying Memory.
+func (this *Array[T]) Close() error {
+	return this.mem.Close()
+}
@@ -0,0 +1,105 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import "testing"
+
+type testRecord struct {
+	ID    uint32
+	Value float64
+	Tags  [4]byte
+}
+
+func TestArray(t *testing.T) {
+	array, err := AllocArray[testRecord](4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+	if array.Len() != 4 {
+		t.Error("AllocArray() created the wrong length")
+	}
+	if record, _ := array.At(3); record != (testRecord{}) {
+		t.Error("AllocArray() did not zero the elements")
+	}
+	array.Set(2, testRecord{ID: 7, Value: 1.5})
+	if record, _ := array.At(2); record.ID != 7 || record.Value != 1.5 {
+		t.Error("Set() stored the wrong element")
+	}
+	if _, err := array.At(4); err != ErrOutOfRange {
+		t.Error("At() read past the end")
+	}
+	if err := array.Set(4, testRecord{}); err != ErrOutOfRange {
+		t.Error("Set() wrote past the end")
+	}
+	if array.Slice()[2].ID != 7 {
+		t.Error("Slice() did not share the block")
+	}
+}
+
+func TestArrayAppend(t *testing.T) {
+	array, _ := AllocArray[int64](0)
+	for i := int64(0); i < 100; i++ {
+		if err := array.Append(i * i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if array.Len() != 100 || array.Cap() < 100 {
+		t.Error("Append() did not grow the array")
+	}
+	var count uint64
+	for i, value := range array.All() {
+		if value != int64(i*i) {
+			t.Error("All() returned the wrong element")
+		}
+		count += 1
+		if i == 49 {
+			break
+		}
+	}
+	if count != 50 {
+		t.Error("All() did not stop when asked")
+	}
+	if array.Pointer() != array.Memory().Cbuf {
+		t.Error("Pointer() returned the wrong address")
+	}
+}
+
+func TestArrayElementTypes(t *testing.T) {
+	mem, _ := Alloc(64)
+	if _, err := NewArray[*int](mem); err != ErrInvalidElement {
+		t.Error("NewArray() accepted a pointer type")
+	}
+	if _, err := NewArray[struct{ Name string }](mem); err != ErrInvalidElement {
+		t.Error("NewArray() accepted a struct with a string")
+	}
+	if _, err := NewArray[struct{}](mem); err != ErrInvalidElement {
+		t.Error("NewArray() accepted an empty type")
+	}
+	array, err := NewArray[uint32](mem)
+	if err != nil || array.Len() != 16 {
+		t.Error("NewArray() did not cover the whole block")
+	}
+	section, _ := mem.Section(1, 32)
+	if _, err := NewArray[uint32](section); err != ErrMisaligned {
+		t.Error("NewArray() accepted a misaligned block")
+	}
+}
+
+func TestArrayShrunk(t *testing.T) {
+	array, _ := AllocArray[uint64](100)
+	defer array.Close()
+	array.Memory().Grow(8)
+	if _, err := array.At(1000); err != ErrOutOfRange {
+		t.Error("At() read past the end of a shrunk block")
+	}
+	if err := array.Set(1, 1); err != ErrOutOfRange {
+		t.Error("Set() wrote past the end of a shrunk block")
+	}
+	if array.Len() != 1 || len(array.Slice()) != 1 {
+		t.Error("Array not shortened with its block")
+	}
+	if err := array.Append(2); err != nil || array.Len() != 2 {
+		t.Error("Append() failed after the block shrank")
+	}
+}
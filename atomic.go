@@ -0,0 +1,116 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// The atomic operations use the same memory model as sync/atomic and C11's
+// sequentially consistent atomics, so flags and counters in a block can be
+// shared between goroutines and C threads, or between processes when the
+// block is shared memory. The word at offset has to be naturally aligned, or
+// they return ErrMisaligned.
+
+// atomicAddress returns the address of the size byte word at offset, checking
+// that it is inside the block and aligned.
+func (this *Memory) atomicAddress(offset uint64, size uint64) (unsafe.Pointer, error) {
+	if err := this.readable(); err != nil {
+		return nil, err
+	}
+	if offset > this.Size || this.Size-offset < size {
+		return nil, ErrOutOfRange
+	}
+	address := unsafe.Add(this.Cbuf, offset)
+	if uintptr(address)%uintptr(size) != 0 {
+		return nil, ErrMisaligned
+	}
+	return address, nil
+}
+
+// atomicWritableAddress is atomicAddress for operations that store.
+func (this *Memory) atomicWritableAddress(offset uint64, size uint64) (unsafe.Pointer, error) {
+	if err := this.writable(); err != nil {
+		return nil, err
+	}
+	return this.atomicAddress(offset, size)
+}
+
+// AtomicLoad32 atomically loads the 32-bit word at offset.
+func (this *Memory) AtomicLoad32(offset uint64) (uint32, error) {
+	address, err := this.atomicAddress(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.LoadUint32((*uint32)(address)), nil
+}
+
+// AtomicLoad64 atomically loads the 64-bit word at offset.
+func (this *Memory) AtomicLoad64(offset uint64) (uint64, error) {
+	address, err := this.atomicAddress(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.LoadUint64((*uint64)(address)), nil
+}
+
+// AtomicStore32 atomically stores value in the 32-bit word at offset.
+func (this *Memory) AtomicStore32(offset uint64, value uint32) error {
+	address, err := this.atomicWritableAddress(offset, 4)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint32((*uint32)(address), value)
+	return nil
+}
+
+// AtomicStore64 atomically stores value in the 64-bit word at offset.
+func (this *Memory) AtomicStore64(offset uint64, value uint64) error {
+	address, err := this.atomicWritableAddress(offset, 8)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint64((*uint64)(address), value)
+	return nil
+}
+
+// AtomicAdd32 atomically adds delta to the 32-bit word at offset and returns
+// the new value. As with sync/atomic, add ^uint32(n-1) to subtract n.
+func (this *Memory) AtomicAdd32(offset uint64, delta uint32) (uint32, error) {
+	address, err := this.atomicWritableAddress(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.AddUint32((*uint32)(address), delta), nil
+}
+
+// AtomicAdd64 atomically adds delta to the 64-bit word at offset and returns
+// the new value. As with sync/atomic, add ^uint64(n-1) to subtract n.
+func (this *Memory) AtomicAdd64(offset uint64, delta uint64) (uint64, error) {
+	address, err := this.atomicWritableAddress(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.AddUint64((*uint64)(address), delta), nil
+}
+
+// CompareAndSwap32 atomically replaces the 32-bit word at offset with new if
+// it holds old, and returns whether it did.
+func (this *Memory) CompareAndSwap32(offset uint64, old uint32, new uint32) (bool, error) {
+	address, err := this.atomicWritableAddress(offset, 4)
+	if err != nil {
+		return false, err
+	}
+	return atomic.CompareAndSwapUint32((*uint32)(address), old, new), nil
+}
+
+// CompareAndSwap64 atomically replaces the 64-bit word at offset with new if
+// it holds old, and returns whether it did.
+func (this *Memory) CompareAndSwap64(offset uint64, old uint64, new uint64) (bool, error) {
+	address, err := this.atomicWritableAddress(offset, 8)
+	if err != nil {
+		return false, err
+	}
+	return atomic.CompareAndSwapUint64((*uint64)(address), old, new), nil
+}
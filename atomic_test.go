@@ -0,0 +1,93 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestAtomicOperations(t *testing.T) {
+	mem, _ := AllocAligned(64, 8)
+	defer mem.Close()
+	clear(mem.Bytes())
+	if err := mem.AtomicStore32(4, 7); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := mem.AtomicLoad32(4); value != 7 {
+		t.Error("AtomicLoad32() loaded the wrong value")
+	}
+	if value, _ := mem.AtomicAdd32(4, ^uint32(0)); value != 6 {
+		t.Error("AtomicAdd32() didn't subtract")
+	}
+	mem.AtomicStore64(8, 1<<40)
+	if value, _ := mem.AtomicAdd64(8, 2); value != 1<<40+2 {
+		t.Error("AtomicAdd64() returned the wrong value")
+	}
+	if swapped, _ := mem.CompareAndSwap64(8, 1, 5); swapped {
+		t.Error("CompareAndSwap64() swapped a different value")
+	}
+	if swapped, _ := mem.CompareAndSwap64(8, 1<<40+2, 5); !swapped {
+		t.Error("CompareAndSwap64() didn't swap")
+	}
+	if swapped, _ := mem.CompareAndSwap32(4, 6, 9); !swapped {
+		t.Error("CompareAndSwap32() didn't swap")
+	}
+	if value, _ := mem.AtomicLoad64(8); value != 5 {
+		t.Error("AtomicLoad64() loaded the wrong value")
+	}
+	if value := *(*uint32)(unsafe.Add(mem.Cbuf, 4)); value != 9 {
+		t.Error("Atomic operations wrote to the wrong place")
+	}
+}
+
+func TestAtomicChecks(t *testing.T) {
+	mem, _ := AllocAligned(16, 8)
+	if _, err := mem.AtomicLoad64(4); err != ErrMisaligned {
+		t.Error("AtomicLoad64() allowed a misaligned word")
+	}
+	if err := mem.AtomicStore32(2, 1); err != ErrMisaligned {
+		t.Error("AtomicStore32() allowed a misaligned word")
+	}
+	if _, err := mem.AtomicAdd64(16, 1); err != ErrOutOfRange {
+		t.Error("AtomicAdd64() went past the end of the block")
+	}
+	if _, err := mem.AtomicLoad32(1 << 63); err != ErrOutOfRange {
+		t.Error("AtomicLoad32() allowed an overflowing offset")
+	}
+	reader, _ := mem.NewReader()
+	if _, err := reader.AtomicLoad64(8); err != nil {
+		t.Error("AtomicLoad64() failed on a read-only view")
+	}
+	if _, err := reader.CompareAndSwap32(0, 0, 1); err != ErrReadOnly {
+		t.Error("CompareAndSwap32() wrote to a read-only view")
+	}
+	mem.Close()
+	if _, err := mem.AtomicLoad32(0); err != ErrClosed {
+		t.Error("AtomicLoad32() didn't return ErrClosed")
+	}
+}
+
+func TestAtomicWithC(t *testing.T) {
+	const count = 100000
+	mem, _ := AllocAligned(8, 8)
+	defer mem.Close()
+	mem.AtomicStore64(0, 0)
+	var group sync.WaitGroup
+	group.Add(2)
+	go func() {
+		testAtomicAddLoop(mem.Cbuf, count)
+		group.Done()
+	}()
+	go func() {
+		for i := 0; i < count; i++ {
+			mem.AtomicAdd64(0, 1)
+		}
+		group.Done()
+	}()
+	group.Wait()
+	if value, _ := mem.AtomicLoad64(0); value != 2*count {
+		t.Errorf("Counter is %d after concurrent adds from Go and C", value)
+	}
+}
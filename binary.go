@@ -0,0 +1,456 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import "C"
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// sizeofSize is the width of a C size_t.
+const sizeofSize = uint64(unsafe.Sizeof(C.size_t(0)))
+
+// sizeofPointer is the width of a C pointer.
+const sizeofPointer = uint64(unsafe.Sizeof(unsafe.Pointer(nil)))
+
+// next returns the length bytes at the cursor and moves the cursor past them.
+// It returns io.EOF at the end of the block, or io.ErrUnexpectedEOF if only part
+// of the value is left, without moving the cursor.
+func (this *Memory) next(length uint64) ([]byte, error) {
+	if err := this.readable(); err != nil {
+		return nil, err
+	}
+	if this.cursor == this.Size {
+		return nil, io.EOF
+	}
+	if this.Size-this.cursor < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := this.Bytes()[this.cursor : this.cursor+length]
+	this.cursor += length
+	return data, nil
+}
+
+// writeValue writes the encoded value at the cursor. A fixed size block that
+// doesn't have room for all of it is left untouched and ErrOutOfRange is
+// returned.
+func (this *Memory) writeValue(data []byte) error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if !this.growable && this.Size-this.cursor < uint64(len(data)) {
+		return ErrOutOfRange
+	}
+	_, err := this.Write(data)
+	return err
+}
+
+// putValue writes the encoded value at offset.
+func (this *Memory) putValue(offset uint64, data []byte) error {
+	if err := this.writable(); err != nil {
+		return err
+	}
+	output, err := this.Slice(offset, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	copy(output, data)
+	return nil
+}
+
+// Uint8At returns the byte at offset.
+func (this *Memory) Uint8At(offset uint64) (uint8, error) {
+	data, err := this.Slice(offset, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// PutUint8At stores a byte at offset.
+func (this *Memory) PutUint8At(offset uint64, value uint8) error {
+	return this.putValue(offset, []byte{value})
+}
+
+// ReadUint8 reads a byte at the cursor.
+func (this *Memory) ReadUint8() (uint8, error) {
+	data, err := this.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// WriteUint8 writes a byte at the cursor.
+func (this *Memory) WriteUint8(value uint8) error {
+	return this.writeValue([]byte{value})
+}
+
+// Int8At returns the int8 at offset.
+func (this *Memory) Int8At(offset uint64) (int8, error) {
+	value, err := this.Uint8At(offset)
+	return int8(value), err
+}
+
+// PutInt8At stores an int8 at offset.
+func (this *Memory) PutInt8At(offset uint64, value int8) error {
+	return this.PutUint8At(offset, uint8(value))
+}
+
+// ReadInt8 reads an int8 at the cursor.
+func (this *Memory) ReadInt8() (int8, error) {
+	value, err := this.ReadUint8()
+	return int8(value), err
+}
+
+// WriteInt8 writes an int8 at the cursor.
+func (this *Memory) WriteInt8(value int8) error {
+	return this.WriteUint8(uint8(value))
+}
+
+// Uint16At returns the uint16 at offset in the given byte order.
+func (this *Memory) Uint16At(offset uint64, order binary.ByteOrder) (uint16, error) {
+	data, err := this.Slice(offset, 2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint16(data), nil
+}
+
+// PutUint16At stores a uint16 at offset in the given byte order.
+func (this *Memory) PutUint16At(offset uint64, order binary.ByteOrder, value uint16) error {
+	var data [2]byte
+	order.PutUint16(data[:], value)
+	return this.putValue(offset, data[:])
+}
+
+// ReadUint16 reads a uint16 at the cursor in the given byte order.
+func (this *Memory) ReadUint16(order binary.ByteOrder) (uint16, error) {
+	data, err := this.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint16(data), nil
+}
+
+// WriteUint16 writes a uint16 at the cursor in the given byte order.
+func (this *Memory) WriteUint16(order binary.ByteOrder, value uint16) error {
+	var data [2]byte
+	order.PutUint16(data[:], value)
+	return this.writeValue(data[:])
+}
+
+// Uint32At returns the uint32 at offset in the given byte order.
+func (this *Memory) Uint32At(offset uint64, order binary.ByteOrder) (uint32, error) {
+	data, err := this.Slice(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(data), nil
+}
+
+// PutUint32At stores a uint32 at offset in the given byte order.
+func (this *Memory) PutUint32At(offset uint64, order binary.ByteOrder, value uint32) error {
+	var data [4]byte
+	order.PutUint32(data[:], value)
+	return this.putValue(offset, data[:])
+}
+
+// ReadUint32 reads a uint32 at the cursor in the given byte order.
+func (this *Memory) ReadUint32(order binary.ByteOrder) (uint32, error) {
+	data, err := this.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(data), nil
+}
+
+// WriteUint32 writes a uint32 at the cursor in the given byte order.
+func (this *Memory) WriteUint32(order binary.ByteOrder, value uint32) error {
+	var data [4]byte
+	order.PutUint32(data[:], value)
+	return this.writeValue(data[:])
+}
+
+// Uint64At returns the uint64 at offset in the given byte order.
+func (this *Memory) Uint64At(offset uint64, order binary.ByteOrder) (uint64, error) {
+	data, err := this.Slice(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(data), nil
+}
+
+// PutUint64At stores a uint64 at offset in the given byte order.
+func (this *Memory) PutUint64At(offset uint64, order binary.ByteOrder, value uint64) error {
+	var data [8]byte
+	order.PutUint64(data[:], value)
+	return this.putValue(offset, data[:])
+}
+
+// ReadUint64 reads a uint64 at the cursor in the given byte order.
+func (this *Memory) ReadUint64(order binary.ByteOrder) (uint64, error) {
+	data, err := this.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(data), nil
+}
+
+// WriteUint64 writes a uint64 at the cursor in the given byte order.
+func (this *Memory) WriteUint64(order binary.ByteOrder, value uint64) error {
+	var data [8]byte
+	order.PutUint64(data[:], value)
+	return this.writeValue(data[:])
+}
+
+// Int16At returns the int16 at offset in the given byte order.
+func (this *Memory) Int16At(offset uint64, order binary.ByteOrder) (int16, error) {
+	data, err := this.Slice(offset, 2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(order.Uint16(data)), nil
+}
+
+// PutInt16At stores an int16 at offset in the given byte order.
+func (this *Memory) PutInt16At(offset uint64, order binary.ByteOrder, value int16) error {
+	var data [2]byte
+	order.PutUint16(data[:], uint16(value))
+	return this.putValue(offset, data[:])
+}
+
+// ReadInt16 reads an int16 at the cursor in the given byte order.
+func (this *Memory) ReadInt16(order binary.ByteOrder) (int16, error) {
+	data, err := this.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(order.Uint16(data)), nil
+}
+
+// WriteInt16 writes an int16 at the cursor in the given byte order.
+func (this *Memory) WriteInt16(order binary.ByteOrder, value int16) error {
+	var data [2]byte
+	order.PutUint16(data[:], uint16(value))
+	return this.writeValue(data[:])
+}
+
+// Int32At returns the int32 at offset in the given byte order.
+func (this *Memory) Int32At(offset uint64, order binary.ByteOrder) (int32, error) {
+	data, err := this.Slice(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(order.Uint32(data)), nil
+}
+
+// PutInt32At stores an int32 at offset in the given byte order.
+func (this *Memory) PutInt32At(offset uint64, order binary.ByteOrder, value int32) error {
+	var data [4]byte
+	order.PutUint32(data[:], uint32(value))
+	return this.putValue(offset, data[:])
+}
+
+// ReadInt32 reads an int32 at the cursor in the given byte order.
+func (this *Memory) ReadInt32(order binary.ByteOrder) (int32, error) {
+	data, err := this.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(order.Uint32(data)), nil
+}
+
+// WriteInt32 writes an int32 at the cursor in the given byte order.
+func (this *Memory) WriteInt32(order binary.ByteOrder, value int32) error {
+	var data [4]byte
+	order.PutUint32(data[:], uint32(value))
+	return this.writeValue(data[:])
+}
+
+// Int64At returns the int64 at offset in the given byte order.
+func (this *Memory) Int64At(offset uint64, order binary.ByteOrder) (int64, error) {
+	data, err := this.Slice(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(order.Uint64(data)), nil
+}
+
+// PutInt64At stores an int64 at offset in the given byte order.
+func (this *Memory) PutInt64At(offset uint64, order binary.ByteOrder, value int64) error {
+	var data [8]byte
+	order.PutUint64(data[:], uint64(value))
+	return this.putValue(offset, data[:])
+}
+
+// ReadInt64 reads an int64 at the cursor in the given byte order.
+func (this *Memory) ReadInt64(order binary.ByteOrder) (int64, error) {
+	data, err := this.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(order.Uint64(data)), nil
+}
+
+// WriteInt64 writes an int64 at the cursor in the given byte order.
+func (this *Memory) WriteInt64(order binary.ByteOrder, value int64) error {
+	var data [8]byte
+	order.PutUint64(data[:], uint64(value))
+	return this.writeValue(data[:])
+}
+
+// Float32At returns the float32 at offset in the given byte order.
+func (this *Memory) Float32At(offset uint64, order binary.ByteOrder) (float32, error) {
+	data, err := this.Slice(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(order.Uint32(data)), nil
+}
+
+// PutFloat32At stores a float32 at offset in the given byte order.
+func (this *Memory) PutFloat32At(offset uint64, order binary.ByteOrder, value float32) error {
+	var data [4]byte
+	order.PutUint32(data[:], math.Float32bits(value))
+	return this.putValue(offset, data[:])
+}
+
+// ReadFloat32 reads a float32 at the cursor in the given byte order.
+func (this *Memory) ReadFloat32(order binary.ByteOrder) (float32, error) {
+	data, err := this.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(order.Uint32(data)), nil
+}
+
+// WriteFloat32 writes a float32 at the cursor in the given byte order.
+func (this *Memory) WriteFloat32(order binary.ByteOrder, value float32) error {
+	var data [4]byte
+	order.PutUint32(data[:], math.Float32bits(value))
+	return this.writeValue(data[:])
+}
+
+// Float64At returns the float64 at offset in the given byte order.
+func (this *Memory) Float64At(offset uint64, order binary.ByteOrder) (float64, error) {
+	data, err := this.Slice(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(order.Uint64(data)), nil
+}
+
+// PutFloat64At stores a float64 at offset in the given byte order.
+func (this *Memory) PutFloat64At(offset uint64, order binary.ByteOrder, value float64) error {
+	var data [8]byte
+	order.PutUint64(data[:], math.Float64bits(value))
+	return this.putValue(offset, data[:])
+}
+
+// ReadFloat64 reads a float64 at the cursor in the given byte order.
+func (this *Memory) ReadFloat64(order binary.ByteOrder) (float64, error) {
+	data, err := this.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(order.Uint64(data)), nil
+}
+
+// WriteFloat64 writes a float64 at the cursor in the given byte order.
+func (this *Memory) WriteFloat64(order binary.ByteOrder, value float64) error {
+	var data [8]byte
+	order.PutUint64(data[:], math.Float64bits(value))
+	return this.writeValue(data[:])
+}
+
+// decodeSize decodes a native size_t.
+func decodeSize(data []byte) uint64 {
+	if sizeofSize == 4 {
+		return uint64(binary.NativeEndian.Uint32(data))
+	}
+	return binary.NativeEndian.Uint64(data)
+}
+
+// encodeSize encodes a native size_t.
+func encodeSize(value uint64) []byte {
+	data := make([]byte, sizeofSize)
+	if sizeofSize == 4 {
+		binary.NativeEndian.PutUint32(data, uint32(value))
+	} else {
+		binary.NativeEndian.PutUint64(data, value)
+	}
+	return data
+}
+
+// SizeAt returns the C size_t at offset, in the platform's width and byte
+// order.
+func (this *Memory) SizeAt(offset uint64) (uint64, error) {
+	data, err := this.Slice(offset, sizeofSize)
+	if err != nil {
+		return 0, err
+	}
+	return decodeSize(data), nil
+}
+
+// PutSizeAt stores a C size_t at offset, in the platform's width and byte
+// order.
+func (this *Memory) PutSizeAt(offset uint64, value uint64) error {
+	return this.putValue(offset, encodeSize(value))
+}
+
+// ReadSize reads a C size_t at the cursor.
+func (this *Memory) ReadSize() (uint64, error) {
+	data, err := this.next(sizeofSize)
+	if err != nil {
+		return 0, err
+	}
+	return decodeSize(data), nil
+}
+
+// WriteSize writes a C size_t at the cursor.
+func (this *Memory) WriteSize(value uint64) error {
+	return this.writeValue(encodeSize(value))
+}
+
+// PointerAt returns the C pointer stored at offset.
+func (this *Memory) PointerAt(offset uint64) (unsafe.Pointer, error) {
+	data, err := this.Slice(offset, sizeofPointer)
+	if err != nil {
+		return nil, err
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(&data[0])), nil
+}
+
+// PutPointerAt stores a C pointer at offset. Following the cgo rules, pointer
+// must not point to Go memory.
+func (this *Memory) PutPointerAt(offset uint64, pointer unsafe.Pointer) error {
+	if err := this.writable(); err != nil {
+		return err
+	}
+	data, err := this.Slice(offset, sizeofPointer)
+	if err != nil {
+		return err
+	}
+	*(*unsafe.Pointer)(unsafe.Pointer(&data[0])) = pointer
+	return nil
+}
+
+// ReadPointer reads a C pointer at the cursor.
+func (this *Memory) ReadPointer() (unsafe.Pointer, error) {
+	data, err := this.next(sizeofPointer)
+	if err != nil {
+		return nil, err
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(&data[0])), nil
+}
+
+// WritePointer writes a C pointer at the cursor. Following the cgo rules,
+// pointer must not point to Go memory.
+func (this *Memory) WritePointer(pointer unsafe.Pointer) error {
+	return this.writeValue(unsafe.Slice((*byte)(unsafe.Pointer(&pointer)), sizeofPointer))
+}
@@ -0,0 +1,119 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func TestTypedAt(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	value16, err := mem.Uint16At(1, binary.LittleEndian)
+	if err != nil || value16 != 0x0201 {
+		t.Error("Uint16At() decoded the wrong value")
+	}
+	value32, _ := mem.Uint32At(4, binary.BigEndian)
+	if value32 != 0x04050607 {
+		t.Error("Uint32At() decoded the wrong value")
+	}
+	value64, _ := mem.Uint64At(8, binary.LittleEndian)
+	if value64 != 0x0f0e0d0c0b0a0908 {
+		t.Error("Uint64At() decoded the wrong value")
+	}
+	if value8, _ := mem.Int8At(255); value8 != -1 {
+		t.Error("Int8At() decoded the wrong value")
+	}
+
+	mem.PutInt32At(16, binary.LittleEndian, -2)
+	if value, _ := mem.Int32At(16, binary.LittleEndian); value != -2 {
+		t.Error("PutInt32At() stored the wrong value")
+	}
+	mem.PutFloat64At(24, binary.BigEndian, math.Pi)
+	if value, _ := mem.Float64At(24, binary.BigEndian); value != math.Pi {
+		t.Error("PutFloat64At() stored the wrong value")
+	}
+	mem.PutFloat32At(32, binary.LittleEndian, 1.5)
+	if value, _ := mem.Uint32At(32, binary.LittleEndian); value != math.Float32bits(1.5) {
+		t.Error("PutFloat32At() stored the wrong value")
+	}
+
+	if _, err := mem.Uint64At(250, binary.LittleEndian); err != ErrOutOfRange {
+		t.Error("Uint64At() read past the end of the block")
+	}
+	if err := mem.PutUint16At(255, binary.LittleEndian, 1); err != ErrOutOfRange {
+		t.Error("PutUint16At() wrote past the end of the block")
+	}
+	if _, err := mem.Uint8At(1 << 63); err != ErrOutOfRange {
+		t.Error("Uint8At() accepted a huge offset")
+	}
+}
+
+func TestTypedCursor(t *testing.T) {
+	mem, _ := Alloc(16)
+	mem.WriteUint16(binary.BigEndian, 0xabcd)
+	mem.WriteInt64(binary.LittleEndian, -5)
+	mem.WriteFloat32(binary.LittleEndian, 2.5)
+	if err := mem.WriteUint32(binary.LittleEndian, 1); err != ErrOutOfRange {
+		t.Error("WriteUint32() wrote past the end of the block")
+	}
+	if mem.cursor != 14 {
+		t.Error("A failed write moved the cursor")
+	}
+	mem.Seek(0, 0)
+	if value, _ := mem.ReadUint16(binary.BigEndian); value != 0xabcd {
+		t.Error("ReadUint16() decoded the wrong value")
+	}
+	if value, _ := mem.ReadInt64(binary.LittleEndian); value != -5 {
+		t.Error("ReadInt64() decoded the wrong value")
+	}
+	if value, _ := mem.ReadFloat32(binary.LittleEndian); value != 2.5 {
+		t.Error("ReadFloat32() decoded the wrong value")
+	}
+	if _, err := mem.ReadUint32(binary.LittleEndian); err != io.ErrUnexpectedEOF {
+		t.Error("ReadUint32() did not report a truncated value")
+	}
+	mem.Seek(0, 2)
+	if _, err := mem.ReadUint8(); err != io.EOF {
+		t.Error("ReadUint8() failed to return EOF")
+	}
+
+	buffer, _ := AllocBuffer(0)
+	buffer.WriteUint64(binary.LittleEndian, 7)
+	if buffer.Size != 8 {
+		t.Error("WriteUint64() did not extend a growable block")
+	}
+}
+
+func TestSizeAndPointer(t *testing.T) {
+	mem, _ := Alloc(64)
+	if err := mem.PutSizeAt(0, 12345); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := mem.SizeAt(0); value != 12345 {
+		t.Error("SizeAt() decoded the wrong value")
+	}
+	mem.PutPointerAt(16, mem.Cbuf)
+	if pointer, _ := mem.PointerAt(16); pointer != mem.Cbuf {
+		t.Error("PointerAt() decoded the wrong pointer")
+	}
+	if *(*unsafe.Pointer)(unsafe.Add(mem.Cbuf, 16)) != mem.Cbuf {
+		t.Error("PutPointerAt() did not store a native pointer")
+	}
+	mem.Seek(32, 0)
+	mem.WriteSize(99)
+	mem.WritePointer(mem.Cbuf)
+	mem.Seek(32, 0)
+	if value, _ := mem.ReadSize(); value != 99 {
+		t.Error("ReadSize() decoded the wrong value")
+	}
+	if pointer, _ := mem.ReadPointer(); pointer != mem.Cbuf {
+		t.Error("ReadPointer() decoded the wrong pointer")
+	}
+	if _, err := mem.PointerAt(60); err != ErrOutOfRange {
+		t.Error("PointerAt() read past the end of the block")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright © 2014 Emily Maier
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// generator writes Go accessors for parsed structs.
+type generator struct {
+	body        bytes.Buffer
+	usesBinary  bool
+	usesBytes   bool
+	usesPointer bool
+}
+
+// generate returns formatted Go source declaring accessors for structs in
+// package packageName. source names the header in the generated comment.
+func generate(packageName string, source string, structs []*cStruct) ([]byte, error) {
+	this := &generator{}
+	for _, record := range structs {
+		this.writeStruct(record)
+	}
+	var output bytes.Buffer
+	fmt.Fprintf(&output, "// Code generated by cmemory-gen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&output, "package %s\n\nimport (\n", packageName)
+	if this.usesBytes {
+		output.WriteString("\t\"bytes\"\n")
+	}
+	if this.usesBinary {
+		output.WriteString("\t\"encoding/binary\"\n")
+	}
+	if this.usesPointer {
+		output.WriteString("\t\"unsafe\"\n")
+	}
+	output.WriteString("\n\t\"github.com/emilymaier/cmemory\"\n)\n")
+	output.Write(this.body.Bytes())
+	formatted, err := format.Source(output.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid Go: %v", err)
+	}
+	return formatted, nil
+}
+
+func (this *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&this.body, format, args...)
+}
+
+// describe returns how the C type of a struct is referred to in comments.
+func describe(record *cStruct) string {
+	if record.cName == "" {
+		return "an anonymous C struct"
+	}
+	return "the C struct " + record.cName
+}
+
+func (this *generator) writeStruct(record *cStruct) {
+	name := record.goName
+	this.printf("\n// %s is a view of %s at an offset in a Memory.\n", name, describe(record))
+	this.printf("type %s struct {\n\tmem *cmemory.Memory\n\toffset uint64\n}\n", name)
+	this.printf("\n// Offsets of the fields of %s, and its size in bytes.\nconst (\n", name)
+	for _, member := range record.members {
+		this.printf("\tOffsetof%s%s = %d\n", name, member.goName, member.offset)
+	}
+	this.printf("\tSizeof%s = %d\n)\n", name, record.size)
+	this.printf("\n// %sAt returns a view of the %s at offset in mem.\n", name, name)
+	this.printf("func %sAt(mem *cmemory.Memory, offset uint64) %s {\n\treturn %s{mem, offset}\n}\n", name, name, name)
+	for _, member := range record.members {
+		if member.count == 0 {
+			this.writeMember(record, member)
+		} else {
+			this.writeArray(record, member)
+		}
+	}
+}
+
+// accessors returns the names of the Memory methods that load and store a Go
+// type, and whether they take a byte order.
+func accessors(goType string) (string, string, bool) {
+	switch goType {
+	case "unsafe.Pointer":
+		return "PointerAt", "PutPointerAt", false
+	case "uint8", "bool":
+		return "Uint8At", "PutUint8At", false
+	case "int8":
+		return "Int8At", "PutInt8At", false
+	}
+	method := strings.ToUpper(goType[:1]) + goType[1:] + "At"
+	return method, "Put" + method, true
+}
+
+// access returns the arguments that come before the value in an accessor call.
+func (this *generator) access(goType string, offset string) (string, string, string) {
+	load, store, ordered := accessors(goType)
+	if goType == "unsafe.Pointer" {
+		this.usesPointer = true
+	}
+	if ordered {
+		this.usesBinary = true
+		offset += ", binary.NativeEndian"
+	}
+	return load, store, offset
+}
+
+func (this *generator) writeMember(record *cStruct, member cMember) {
+	name := record.goName
+	offset := fmt.Sprintf("this.offset+Offsetof%s%s", name, member.goName)
+	if member.ctype.record != nil {
+		nested := member.ctype.record.goName
+		this.printf("\n// %s returns a view of the %s field.\n", member.goName, member.cName)
+		this.printf("func (this %s) %s() %s {\n\treturn %s{this.mem, %s}\n}\n", name, member.goName, nested, nested, offset)
+		return
+	}
+	goType := member.ctype.goType
+	load, store, args := this.access(goType, offset)
+	this.printf("\n// %s returns the %s field.\n", member.goName, member.cName)
+	if goType == "bool" {
+		this.printf("func (this %s) %s() (bool, error) {\n\tvalue, err := this.mem.%s(%s)\n\treturn value != 0, err\n}\n", name, member.goName, load, args)
+	} else {
+		this.printf("func (this %s) %s() (%s, error) {\n\treturn this.mem.%s(%s)\n}\n", name, member.goName, goType, load, args)
+	}
+	this.printf("\n// Set%s sets the %s field.\n", member.goName, member.cName)
+	if goType == "bool" {
+		this.printf("func (this %s) Set%s(value bool) error {\n\tvar byteValue uint8\n\tif value {\n\t\tbyteValue = 1\n\t}\n\treturn this.mem.%s(%s, byteValue)\n}\n", name, member.goName, store, args)
+	} else {
+		this.printf("func (this %s) Set%s(value %s) error {\n\treturn this.mem.%s(%s, value)\n}\n", name, member.goName, goType, store, args)
+	}
+}
+
+func (this *generator) writeArray(record *cStruct, member cMember) {
+	name := record.goName
+	offset := fmt.Sprintf("this.offset+Offsetof%s%s+index*%d", name, member.goName, member.ctype.size)
+	check := fmt.Sprintf("\tif index >= %d {\n\t\treturn %%s cmemory.ErrOutOfRange\n\t}\n", member.count)
+	if member.ctype.record != nil {
+		nested := member.ctype.record.goName
+		this.printf("\n// %s returns a view of element index of the %s field.\n", member.goName, member.cName)
+		this.printf("func (this %s) %s(index uint64) (%s, error) {\n", name, member.goName, nested)
+		this.printf(check, nested+"{},")
+		this.printf("\treturn %s{this.mem, %s}, nil\n}\n", nested, offset)
+		return
+	}
+	goType := member.ctype.goType
+	zero := "0,"
+	switch goType {
+	case "bool":
+		zero = "false,"
+	case "unsafe.Pointer":
+		zero = "nil,"
+	}
+	load, store, args := this.access(goType, offset)
+	this.printf("\n// %s returns element index of the %s field.\n", member.goName, member.cName)
+	this.printf("func (this %s) %s(index uint64) (%s, error) {\n", name, member.goName, goType)
+	this.printf(check, zero)
+	if goType == "bool" {
+		this.printf("\tvalue, err := this.mem.%s(%s)\n\treturn value != 0, err\n}\n", load, args)
+	} else {
+		this.printf("\treturn this.mem.%s(%s)\n}\n", load, args)
+	}
+	this.printf("\n// Set%s sets element index of the %s field.\n", member.goName, member.cName)
+	this.printf("func (this %s) Set%s(index uint64, value %s) error {\n", name, member.goName, goType)
+	this.printf(check, "")
+	if goType == "bool" {
+		this.printf("\tvar byteValue uint8\n\tif value {\n\t\tbyteValue = 1\n\t}\n\treturn this.mem.%s(%s, byteValue)\n}\n", store, args)
+	} else {
+		this.printf("\treturn this.mem.%s(%s, value)\n}\n", store, args)
+	}
+	if member.ctype.size != 1 || goType == "bool" {
+		return
+	}
+	// char arrays are usually strings.
+	field := fmt.Sprintf("this.offset+Offsetof%s%s, %d", name, member.goName, member.count)
+	this.printf("\n// %sString returns the %s field up to its first NUL byte.\n", member.goName, member.cName)
+	this.printf("func (this %s) %sString() (string, error) {\n", name, member.goName)
+	this.printf("\tdata, err := this.mem.Slice(%s)\n\tif err != nil {\n\t\treturn \"\", err\n\t}\n", field)
+	this.printf("\tif end := bytes.IndexByte(data, 0); end >= 0 {\n\t\tdata = data[:end]\n\t}\n\treturn string(data), nil\n}\n")
+	this.printf("\n// Set%sString stores value in the %s field, padding it with NUL bytes. It\n", member.goName, member.cName)
+	this.printf("// returns cmemory.ErrOutOfRange if value is longer than the field.\n")
+	this.printf("func (this %s) Set%sString(value string) error {\n", name, member.goName)
+	this.printf("\tif len(value) > %d {\n\t\treturn cmemory.ErrOutOfRange\n\t}\n", member.count)
+	this.printf("\tdata := make([]byte, %d)\n\tcopy(data, value)\n", member.count)
+	this.printf("\twritten, err := this.mem.WriteAt(data, int64(this.offset+Offsetof%s%s))\n", name, member.goName)
+	this.printf("\tif err == nil && written < len(data) {\n\t\terr = cmemory.ErrOutOfRange\n\t}\n\treturn err\n}\n")
+	this.usesBytes = true
+}
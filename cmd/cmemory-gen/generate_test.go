@@ -0,0 +1,46 @@
+// Copyright © 2014 Emily Maier
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	generated, err := generate("device", "device.h", parseTest(t, testHeader).structs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := string(generated)
+	expected := []string{
+		"// Code generated by cmemory-gen from device.h. DO NOT EDIT.",
+		"OffsetofDeviceOrigin = 24",
+		"SizeofDevice         = 176",
+		"func (this Device) Id() (uint32, error) {\n\treturn this.mem.Uint32At(this.offset+OffsetofDeviceId, binary.NativeEndian)",
+		"func (this Device) SetS(value int8) error {\n\treturn this.mem.PutInt8At(this.offset+OffsetofDeviceS, value)",
+		"func (this Device) Origin() Point {",
+		"func (this Device) Path(index uint64) (Point, error) {\n\tif index >= 3 {",
+		"func (this Device) Matrix(index uint64) (float32, error) {\n\tif index >= 6 {",
+		"func (this Device) SetData(value unsafe.Pointer) error {",
+		"func (this Device) On() (bool, error) {",
+		"func (this Device) SetNameString(value string) error {",
+	}
+	for _, text := range expected {
+		if !strings.Contains(source, text) {
+			t.Errorf("Generated code is missing %q", text)
+		}
+	}
+}
+
+func TestGenerateImports(t *testing.T) {
+	generated, err := generate("wire", "wire.h", parseTest(t, "struct wire { uint8_t tag; };").structs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, unused := range []string{`"bytes"`, `"encoding/binary"`, `"unsafe"`} {
+		if strings.Contains(string(generated), unused) {
+			t.Errorf("Unused package %s imported", unused)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright © 2014 Emily Maier
+
+// Command cmemory-gen generates typed accessors for C structs that live in a
+// cmemory.Memory.
+//
+// It reads the struct and typedef declarations in a C header and writes a Go
+// file with a view type for each struct, offset and size constants, and a
+// getter and setter for each field:
+//
+//	cmemory-gen -package device -o device_gen.go device.h
+//
+// Fields can be fixed-width or plain C integers, floats, bools, pointers,
+// arrays, enums, and nested structs. Offsets are computed with the usual C
+// alignment rules for an LP64 platform such as amd64 or arm64 Linux, and
+// __attribute__((packed)) is honored. Unions, bit-fields, and function pointers
+// are not supported. Preprocessor directives are ignored, so macros can't be
+// used for array sizes.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	packageName := flag.String("package", "main", "package name of the generated file")
+	output := flag.String("o", "", "file to write to instead of standard output")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: cmemory-gen [flags] header.h\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	err := run(flag.Arg(0), *packageName, *output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cmemory-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(header string, packageName string, output string) error {
+	source, err := os.ReadFile(header)
+	if err != nil {
+		return err
+	}
+	parser := newParser(string(source))
+	err = parser.parse()
+	if err != nil {
+		return fmt.Errorf("%s: %v", header, err)
+	}
+	generated, err := generate(packageName, filepath.Base(header), parser.structs)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(generated)
+		return err
+	}
+	return os.WriteFile(output, generated, 0666)
+}
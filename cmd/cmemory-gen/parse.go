@@ -0,0 +1,606 @@
+// Copyright © 2014 Emily Maier
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token is a single C token and the line it was found on.
+type token struct {
+	text string
+	line int
+}
+
+// tokenize splits C source into tokens, dropping comments and preprocessor
+// directives.
+func tokenize(source string) []token {
+	tokens := make([]token, 0)
+	line := 1
+	atLineStart := true
+	for i := 0; i < len(source); {
+		char := source[i]
+		switch {
+		case char == '\n':
+			line++
+			atLineStart = true
+			i++
+			continue
+		case char == ' ' || char == '\t' || char == '\r' || char == '\f':
+			i++
+			continue
+		case char == '#' && atLineStart:
+			// Skip the directive, including continuation lines.
+			for i < len(source) && source[i] != '\n' {
+				if source[i] == '\\' && i+1 < len(source) && source[i+1] == '\n' {
+					line++
+					i++
+				}
+				i++
+			}
+			continue
+		case strings.HasPrefix(source[i:], "//"):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(source) - i - 4
+			}
+			line += strings.Count(source[i:i+end+4], "\n")
+			i += end + 4
+			continue
+		}
+		atLineStart = false
+		start := i
+		if isIdentChar(char) {
+			for i < len(source) && isIdentChar(source[i]) {
+				i++
+			}
+		} else {
+			i++
+		}
+		tokens = append(tokens, token{source[start:i], line})
+	}
+	return tokens
+}
+
+func isIdentChar(char byte) bool {
+	return char == '_' || unicode.IsLetter(rune(char)) || unicode.IsDigit(rune(char))
+}
+
+// cType is a C type that can appear in a struct.
+type cType struct {
+	// goType is the Go type that scalars and pointers are accessed as.
+	goType string
+	size   uint64
+	align  uint64
+	// record is set for struct types.
+	record *cStruct
+}
+
+// cStruct is a parsed struct definition.
+type cStruct struct {
+	goName  string
+	cName   string
+	members []cMember
+	size    uint64
+	align   uint64
+	packed  bool
+}
+
+// cMember is a field of a struct. count is the number of elements for arrays
+// and 0 otherwise.
+type cMember struct {
+	cName  string
+	goName string
+	ctype  *cType
+	count  uint64
+	offset uint64
+}
+
+var pointerType = &cType{goType: "unsafe.Pointer", size: 8, align: 8}
+
+// baseTypes maps the C scalar type names of an LP64 platform to their Go
+// equivalents. Multi-word names are normalized by normalizeBase.
+var baseTypes = map[string]*cType{
+	"char":               {goType: "uint8", size: 1, align: 1},
+	"signed char":        {goType: "int8", size: 1, align: 1},
+	"unsigned char":      {goType: "uint8", size: 1, align: 1},
+	"short":              {goType: "int16", size: 2, align: 2},
+	"unsigned short":     {goType: "uint16", size: 2, align: 2},
+	"int":                {goType: "int32", size: 4, align: 4},
+	"unsigned int":       {goType: "uint32", size: 4, align: 4},
+	"long":               {goType: "int64", size: 8, align: 8},
+	"unsigned long":      {goType: "uint64", size: 8, align: 8},
+	"long long":          {goType: "int64", size: 8, align: 8},
+	"unsigned long long": {goType: "uint64", size: 8, align: 8},
+	"float":              {goType: "float32", size: 4, align: 4},
+	"double":             {goType: "float64", size: 8, align: 8},
+	"_Bool":              {goType: "bool", size: 1, align: 1},
+	"bool":               {goType: "bool", size: 1, align: 1},
+	"int8_t":             {goType: "int8", size: 1, align: 1},
+	"uint8_t":            {goType: "uint8", size: 1, align: 1},
+	"int16_t":            {goType: "int16", size: 2, align: 2},
+	"uint16_t":           {goType: "uint16", size: 2, align: 2},
+	"int32_t":            {goType: "int32", size: 4, align: 4},
+	"uint32_t":           {goType: "uint32", size: 4, align: 4},
+	"int64_t":            {goType: "int64", size: 8, align: 8},
+	"uint64_t":           {goType: "uint64", size: 8, align: 8},
+	"size_t":             {goType: "uint64", size: 8, align: 8},
+	"ssize_t":            {goType: "int64", size: 8, align: 8},
+	"intptr_t":           {goType: "int64", size: 8, align: 8},
+	"uintptr_t":          {goType: "uint64", size: 8, align: 8},
+	"ptrdiff_t":          {goType: "int64", size: 8, align: 8},
+	"enum":               {goType: "int32", size: 4, align: 4},
+}
+
+// parser turns a token stream into struct definitions.
+type parser struct {
+	tokens   []token
+	pos      int
+	structs  []*cStruct
+	tags     map[string]*cType
+	typedefs map[string]*cType
+}
+
+func newParser(source string) *parser {
+	return &parser{
+		tokens:   tokenize(source),
+		tags:     make(map[string]*cType),
+		typedefs: make(map[string]*cType),
+	}
+}
+
+func (this *parser) peek() string {
+	if this.pos >= len(this.tokens) {
+		return ""
+	}
+	return this.tokens[this.pos].text
+}
+
+func (this *parser) next() string {
+	text := this.peek()
+	this.pos++
+	return text
+}
+
+func (this *parser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if this.pos < len(this.tokens) {
+		line = this.tokens[this.pos].line
+	} else if len(this.tokens) > 0 {
+		line = this.tokens[len(this.tokens)-1].line
+	}
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (this *parser) expect(text string) error {
+	if this.peek() != text {
+		return this.errorf("expected %q, found %q", text, this.peek())
+	}
+	this.pos++
+	return nil
+}
+
+// skipAttributes skips any __attribute__((...)) lists and returns whether one
+// of them was packed.
+func (this *parser) skipAttributes() bool {
+	packed := false
+	for this.peek() == "__attribute__" {
+		this.pos++
+		depth := 0
+		for {
+			text := this.next()
+			if text == "" {
+				return packed
+			}
+			if text == "packed" || text == "__packed__" {
+				packed = true
+			}
+			if text == "(" {
+				depth++
+			} else if text == ")" {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+		}
+	}
+	return packed
+}
+
+// skipDeclaration skips a top-level declaration that isn't a struct or
+// typedef, such as a function prototype or an inline function.
+func (this *parser) skipDeclaration() {
+	depth := 0
+	previous := ""
+	// A function body ends the declaration without a semicolon.
+	functionBody := false
+	for {
+		text := this.next()
+		switch text {
+		case "":
+			return
+		case "{":
+			if depth == 0 {
+				functionBody = previous == ")"
+			}
+			depth++
+		case "}":
+			depth--
+			if depth == 0 && functionBody {
+				return
+			}
+		case ";":
+			if depth <= 0 {
+				return
+			}
+		}
+		previous = text
+	}
+}
+
+// parse parses the whole header.
+func (this *parser) parse() error {
+	for this.peek() != "" {
+		switch this.peek() {
+		case "typedef":
+			this.pos++
+			err := this.parseTypedef()
+			if err != nil {
+				return err
+			}
+		case "struct":
+			_, err := this.parseSpecifiers("")
+			if err != nil {
+				return err
+			}
+			this.skipDeclaration()
+		case ";":
+			this.pos++
+		default:
+			this.skipDeclaration()
+		}
+	}
+	return nil
+}
+
+// parseTypedef parses the rest of a typedef.
+func (this *parser) parseTypedef() error {
+	base, err := this.parseSpecifiers(goTypeName(this.lookaheadName()))
+	if err != nil {
+		return err
+	}
+	for {
+		ctype, name, count, err := this.parseDeclarator(base)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return this.errorf("array typedef %s is not supported", name)
+		}
+		if ctype.record != nil && ctype.record.cName == "" {
+			ctype.record.cName = name
+		}
+		this.typedefs[name] = ctype
+		if this.peek() != "," {
+			break
+		}
+		this.pos++
+	}
+	return this.expect(";")
+}
+
+// parseSpecifiers parses a type up to its declarators. anonymousName is the Go
+// name for an inline struct without a tag.
+func (this *parser) parseSpecifiers(anonymousName string) (*cType, error) {
+	words := make([]string, 0)
+	for {
+		text := this.peek()
+		switch text {
+		case "const", "volatile", "static", "extern", "register", "restrict":
+			this.pos++
+			continue
+		case "__attribute__":
+			this.skipAttributes()
+			continue
+		case "signed", "unsigned", "short", "long", "int", "char", "float", "double", "_Bool", "bool", "void":
+			this.pos++
+			words = append(words, text)
+			continue
+		case "union":
+			return nil, this.errorf("unions are not supported")
+		case "enum":
+			if len(words) > 0 {
+				return nil, this.errorf("unexpected enum")
+			}
+			this.pos++
+			if this.peek() != "{" {
+				this.pos++
+			}
+			if this.peek() == "{" {
+				for this.next() != "}" {
+					if this.peek() == "" {
+						return nil, this.errorf("unterminated enum")
+					}
+				}
+			}
+			return baseTypes["enum"], nil
+		case "struct":
+			if len(words) > 0 {
+				return nil, this.errorf("unexpected struct")
+			}
+			this.pos++
+			packed := this.skipAttributes()
+			tag := ""
+			if this.peek() != "{" {
+				tag = this.next()
+			}
+			packed = this.skipAttributes() || packed
+			if this.peek() == "{" {
+				name := anonymousName
+				if tag != "" {
+					name = goTypeName(tag)
+				}
+				return this.parseStructBody(tag, name, packed)
+			}
+			if ctype, ok := this.tags[tag]; ok {
+				return ctype, nil
+			}
+			// An incomplete struct can only be pointed to.
+			return &cType{size: 0}, nil
+		}
+		if len(words) == 0 {
+			if ctype, ok := this.typedefs[text]; ok {
+				this.pos++
+				return ctype, nil
+			}
+			if ctype, ok := baseTypes[text]; ok {
+				this.pos++
+				return ctype, nil
+			}
+			return nil, this.errorf("unknown type %q", text)
+		}
+		break
+	}
+	name, err := normalizeBase(words)
+	if err != nil {
+		return nil, this.errorf("%v", err)
+	}
+	if name == "void" {
+		return &cType{size: 0}, nil
+	}
+	ctype, ok := baseTypes[name]
+	if !ok {
+		return nil, this.errorf("type %q is not supported", strings.Join(words, " "))
+	}
+	return ctype, nil
+}
+
+// normalizeBase turns a list of C type keywords into a key of baseTypes.
+func normalizeBase(words []string) (string, error) {
+	signed, unsigned, short, long, char, other := false, false, false, 0, false, ""
+	for _, word := range words {
+		switch word {
+		case "signed":
+			signed = true
+		case "unsigned":
+			unsigned = true
+		case "short":
+			short = true
+		case "long":
+			long++
+		case "char":
+			char = true
+		case "int":
+		default:
+			if other != "" {
+				return "", fmt.Errorf("invalid type %q", strings.Join(words, " "))
+			}
+			other = word
+		}
+	}
+	switch {
+	case other == "double" && long > 0:
+		return "", fmt.Errorf("long double is not supported")
+	case other != "":
+		return other, nil
+	}
+	var name string
+	switch {
+	case char:
+		name = "char"
+		if signed {
+			name = "signed char"
+		}
+	case short:
+		name = "short"
+	case long == 1:
+		name = "long"
+	case long == 2:
+		name = "long long"
+	default:
+		name = "int"
+	}
+	if unsigned {
+		name = "unsigned " + name
+	}
+	return name, nil
+}
+
+// parseDeclarator parses pointer stars, a name, and array dimensions.
+// Multi-dimensional arrays are flattened.
+func (this *parser) parseDeclarator(base *cType) (*cType, string, uint64, error) {
+	ctype := base
+	for this.peek() == "*" || this.peek() == "const" || this.peek() == "volatile" || this.peek() == "restrict" {
+		if this.next() == "*" {
+			ctype = pointerType
+		}
+	}
+	if this.peek() == "(" {
+		return nil, "", 0, this.errorf("function pointers are not supported")
+	}
+	name := this.next()
+	if name == "" || !isIdentChar(name[0]) || unicode.IsDigit(rune(name[0])) {
+		return nil, "", 0, this.errorf("expected a name, found %q", name)
+	}
+	var count uint64
+	for this.peek() == "[" {
+		this.pos++
+		dimension, err := strconv.ParseUint(strings.TrimRight(this.next(), "uUlL"), 0, 64)
+		if err != nil {
+			return nil, "", 0, this.errorf("array size must be a number")
+		}
+		if count == 0 {
+			count = dimension
+		} else {
+			count *= dimension
+		}
+		err = this.expect("]")
+		if err != nil {
+			return nil, "", 0, err
+		}
+	}
+	if this.peek() == ":" {
+		return nil, "", 0, this.errorf("bit-fields are not supported")
+	}
+	this.skipAttributes()
+	return ctype, name, count, nil
+}
+
+// parseStructBody parses the members between braces and lays the struct out.
+// tag is the struct tag, if any, and goName the name to generate it as.
+func (this *parser) parseStructBody(tag string, goName string, packed bool) (*cType, error) {
+	err := this.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	if goName == "" {
+		return nil, this.errorf("anonymous struct needs a tag or a typedef")
+	}
+	record := &cStruct{goName: goName, cName: tag}
+	for this.peek() != "}" {
+		if this.peek() == "" {
+			return nil, this.errorf("unterminated struct %s", goName)
+		}
+		if this.peek() == ";" {
+			this.pos++
+			continue
+		}
+		// Inline structs are named after the field that holds them, which
+		// comes after their body.
+		base, err := this.parseSpecifiers(goName + goFieldName(this.lookaheadName()))
+		if err != nil {
+			return nil, err
+		}
+		for {
+			ctype, fieldName, count, err := this.parseDeclarator(base)
+			if err != nil {
+				return nil, err
+			}
+			if ctype.size == 0 {
+				return nil, this.errorf("field %s has an incomplete type", fieldName)
+			}
+			record.members = append(record.members, cMember{cName: fieldName, goName: goFieldName(fieldName), ctype: ctype, count: count})
+			if this.peek() != "," {
+				break
+			}
+			this.pos++
+		}
+		err = this.expect(";")
+		if err != nil {
+			return nil, err
+		}
+	}
+	this.pos++
+	record.packed = this.skipAttributes() || packed
+	layoutStruct(record)
+	ctype := &cType{size: record.size, align: record.align, record: record}
+	if tag != "" {
+		this.tags[tag] = ctype
+	}
+	this.structs = append(this.structs, record)
+	return ctype, nil
+}
+
+// lookaheadName finds the first name declared by the declaration starting at
+// the current token, skipping over any struct body, without consuming
+// anything.
+func (this *parser) lookaheadName() string {
+	depth, parens, name := 0, 0, ""
+	for _, next := range this.tokens[this.pos:] {
+		switch text := next.text; {
+		case text == "{":
+			depth++
+		case text == "}":
+			depth--
+		case text == "(":
+			parens++
+		case text == ")":
+			parens--
+		case depth == 0 && (text == ";" || text == "," || text == "["):
+			return name
+		case depth == 0 && parens == 0 && isIdentChar(text[0]) && text != "__attribute__":
+			name = text
+		}
+	}
+	return name
+}
+
+// layoutStruct assigns member offsets using the C rules for alignment and
+// padding.
+func layoutStruct(record *cStruct) {
+	var offset uint64
+	record.align = 1
+	for index := range record.members {
+		member := &record.members[index]
+		align := member.ctype.align
+		if record.packed {
+			align = 1
+		}
+		offset = (offset + align - 1) / align * align
+		member.offset = offset
+		size := member.ctype.size
+		if member.count != 0 {
+			size *= member.count
+		}
+		offset += size
+		if align > record.align {
+			record.align = align
+		}
+	}
+	record.size = (offset + record.align - 1) / record.align * record.align
+}
+
+// goTypeName turns a C type name such as point_t into an exported Go name.
+func goTypeName(name string) string {
+	return goFieldName(strings.TrimSuffix(name, "_t"))
+}
+
+// goFieldName turns a snake_case C name into an exported CamelCase Go name.
+func goFieldName(name string) string {
+	var builder strings.Builder
+	upper := true
+	for _, char := range name {
+		if char == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			char = unicode.ToUpper(char)
+			upper = false
+		}
+		builder.WriteRune(char)
+	}
+	if builder.Len() == 0 {
+		return "X"
+	}
+	return builder.String()
+}
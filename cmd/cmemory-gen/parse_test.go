@@ -0,0 +1,134 @@
+// Copyright © 2014 Emily Maier
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testHeader = `
+#include <stdint.h>
+#define UNUSED \
+	1
+
+// A device.
+typedef uint32_t device_id_t;
+
+struct point {
+	int16_t x;
+	double y;
+};
+
+typedef struct device {
+	device_id_t id;
+	char name[13];
+	struct point origin;
+	struct {
+		uint8_t a;
+		uint64_t b;
+	} inner;
+	struct point path[3];
+	void *data;
+	const char *label, **argv;
+	_Bool on;
+	unsigned long long big;
+	float matrix[2][3];
+	enum { OFF, ON } mode;
+	signed char s;
+	unsigned short us;
+} device_t;
+
+struct __attribute__((packed)) wire {
+	uint8_t tag;
+	uint32_t length;
+};
+
+int device_open(struct device *device, int flags);
+`
+
+func parseTest(t *testing.T, source string) *parser {
+	parser := newParser(source)
+	err := parser.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parser
+}
+
+func findStruct(t *testing.T, parser *parser, goName string) *cStruct {
+	for _, record := range parser.structs {
+		if record.goName == goName {
+			return record
+		}
+	}
+	t.Fatalf("struct %s not found", goName)
+	return nil
+}
+
+func TestParseLayout(t *testing.T) {
+	parser := parseTest(t, testHeader)
+	if len(parser.structs) != 4 {
+		t.Fatalf("Parsed %d structs", len(parser.structs))
+	}
+	// Offsets as reported by offsetof() with gcc on amd64.
+	device := findStruct(t, parser, "Device")
+	offsets := map[string]uint64{"id": 0, "name": 4, "origin": 24, "inner": 40, "path": 56, "data": 104, "label": 112,
+		"argv": 120, "on": 128, "big": 136, "matrix": 144, "mode": 168, "s": 172, "us": 174}
+	if len(device.members) != len(offsets) {
+		t.Fatalf("Parsed %d members", len(device.members))
+	}
+	for _, member := range device.members {
+		if member.offset != offsets[member.cName] {
+			t.Errorf("Member %s at %d, expected %d", member.cName, member.offset, offsets[member.cName])
+		}
+	}
+	if device.size != 176 || device.align != 8 {
+		t.Errorf("Device is %d bytes aligned to %d", device.size, device.align)
+	}
+	if device.members[10].count != 6 || device.members[6].ctype != pointerType || device.members[7].ctype != pointerType {
+		t.Error("Arrays or pointers parsed wrong")
+	}
+	if findStruct(t, parser, "DeviceInner").size != 16 {
+		t.Error("Inline struct laid out wrong")
+	}
+	if wire := findStruct(t, parser, "Wire"); wire.size != 5 || wire.members[1].offset != 1 {
+		t.Error("Packed struct laid out wrong")
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	if goTypeName("device_t") != "Device" || goTypeName("ring_buffer") != "RingBuffer" || goFieldName("user_id") != "UserId" {
+		t.Error("Names converted wrong")
+	}
+	parser := parseTest(t, "typedef struct { int a; } anonymous_t;")
+	if parser.structs[0].goName != "Anonymous" || parser.structs[0].cName != "anonymous_t" {
+		t.Error("Typedef name not used")
+	}
+}
+
+func TestParseSkipped(t *testing.T) {
+	source := "int table[] = {1, 2};\nstatic inline int f(int x){return x;}\nstruct after {int a;};"
+	parser := parseTest(t, source)
+	if len(parser.structs) != 1 || parser.structs[0].goName != "After" {
+		t.Error("Declaration after an inline function was skipped")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	sources := map[string]string{
+		"union":        "struct a { union { int b; } c; };",
+		"bit-field":    "struct a { int b : 3; };",
+		"function":     "struct a { void (*b)(void); };",
+		"unknown type": "struct a { widget_t b; };",
+		"incomplete":   "struct a { struct b c; };",
+		"array size":   "struct a { int b[SIZE]; };",
+		"unterminated": "struct a { int b;",
+	}
+	for name, source := range sources {
+		err := newParser(source).parse()
+		if err == nil || !strings.HasPrefix(err.Error(), "line 1: ") {
+			t.Errorf("%s: got error %v", name, err)
+		}
+	}
+}
@@ -10,6 +10,7 @@ package cmemory
 
 /*
 #include <stdlib.h>
+#include <string.h>
 #cgo LDFLAGS: -ldl
 
 void start_instrumentation();
@@ -28,13 +29,61 @@ import (
 
 var ErrInvalidWhence = errors.New("Invalid whence parameter")
 var ErrNegativeOffset = errors.New("Attempted to seek to a negative offset")
+var ErrNotOwner = errors.New("Memory block is not owned by this object")
+var ErrNotResizable = errors.New("Memory block cannot be resized")
+var ErrClosed = errors.New("Memory block has been closed")
+var ErrOutOfRange = errors.New("Access is outside of the memory block")
+var ErrNotGrowable = errors.New("Memory block is not growable")
 
-// Memory contains a single block of memory allocated on the C heap.
+// Memory contains a single block of C memory, usually allocated on the C heap.
 type Memory struct {
 	Cbuf   unsafe.Pointer
 	Size   uint64
-	gobuf  []byte
 	cursor uint64
+	// capacity is the number of bytes actually allocated, which can be more
+	// than Size.
+	capacity uint64
+	// align is the alignment requested from AllocAligned, or 0 for blocks
+	// that came from malloc().
+	align uint64
+	// borrowed is set when the block belongs to someone else, such as an
+	// Arena or a C library. Borrowed blocks are never freed or moved by this
+	// object.
+	borrowed bool
+	// readOnly is set for mappings that can't be written to, read-only
+	// sections, and protected blocks from AllocSecure. Sections check their
+	// parents' as well.
+	readOnly bool
+	// noAccess is set while a block from AllocSecure is protected with
+	// NoAccess.
+	noAccess bool
+	// growable is set when writes append past Size instead of stopping.
+	growable bool
+	// closed is set once Close() has released the block.
+	closed bool
+	// parent is the Memory that a section was taken from. It keeps the
+	// parent's block from being finalized while the section is in use.
+	parent *Memory
+	// offset is where a section starts in its parent's block.
+	offset uint64
+	// backing manages blocks that didn't come from malloc(). It is nil for
+	// the C heap.
+	backing backing
+	// accounted is the number of bytes this object adds to LiveBytes().
+	accounted uint64
+	// creation is the stack that created the Memory, when leak reporting
+	// is on.
+	creation []uintptr
+}
+
+// backing frees and resizes blocks that have to be handled differently from
+// the C heap, such as mmap()ed memory.
+type backing interface {
+	// resize replaces the block with one of the given size, keeping its
+	// contents, and updates the Memory to point to it.
+	resize(this *Memory, size uint64) error
+	// free releases the block.
+	free(this *Memory)
 }
 
 // Alloc creates a new Memory struct and allocates on the C heap for it.
@@ -44,12 +93,46 @@ func Alloc(size uint64) (*Memory, error) {
 	if newMemory.Cbuf == nil {
 		return newMemory, errors.New("malloc() could not allocate memory")
 	}
-	runtime.SetFinalizer(newMemory, finalizeMemory)
 	newMemory.Size = size
-	newMemory.gobuf = *(*[]byte)(unsafe.Pointer(&newMemory.Cbuf))
+	newMemory.capacity = size
+	newMemory.own()
+	return newMemory, nil
+}
+
+// AllocAligned creates a new Memory struct whose block starts at a multiple of
+// align, which must be a power of two. The alignment is kept by Grow.
+func AllocAligned(size uint64, align uint64) (*Memory, error) {
+	newMemory := new(Memory)
+	cbuf, err := memalign(size, align)
+	if err != nil {
+		return newMemory, err
+	}
+	newMemory.Cbuf = cbuf
+	newMemory.Size = size
+	newMemory.capacity = size
+	newMemory.align = align
+	newMemory.own()
 	return newMemory, nil
 }
 
+// memalign allocates size bytes aligned to align with posix_memalign().
+func memalign(size uint64, align uint64) (unsafe.Pointer, error) {
+	if !validAlignment(align) {
+		return nil, ErrInvalidAlignment
+	}
+	// posix_memalign() only accepts multiples of sizeof(void*); anything
+	// smaller is satisfied by that anyway.
+	realAlign := align
+	if realAlign < uint64(unsafe.Sizeof(uintptr(0))) {
+		realAlign = uint64(unsafe.Sizeof(uintptr(0)))
+	}
+	var cbuf unsafe.Pointer
+	if C.posix_memalign(&cbuf, C.size_t(realAlign), C.size_t(size)) != 0 {
+		return nil, errors.New("posix_memalign() could not allocate memory")
+	}
+	return cbuf, nil
+}
+
 // AllocFromSlice creates a new Memory struct from an already existing byte
 // slice. The data in the slice is copied into the C heap.
 func AllocFromSlice(data []byte) (*Memory, error) {
@@ -58,41 +141,328 @@ func AllocFromSlice(data []byte) (*Memory, error) {
 	if newMemory.Cbuf == nil {
 		return newMemory, errors.New("malloc() could not allocate memory")
 	}
-	runtime.SetFinalizer(newMemory, finalizeMemory)
 	newMemory.Size = uint64(len(data))
-	newMemory.gobuf = *(*[]byte)(unsafe.Pointer(&newMemory.Cbuf))
-	copy(newMemory.gobuf, data)
+	newMemory.capacity = newMemory.Size
+	copy(newMemory.Bytes(), data)
+	newMemory.own()
 	return newMemory, nil
 }
 
 // WrapMemory creates a new Memory struct from an existing pointer to a C
-// memory block and its size.
+// memory block and its size. The Memory takes ownership of the block and frees
+// it with free(), so the block must have come from malloc().
 func WrapMemory(cbuf unsafe.Pointer, size uint64) *Memory {
 	newMemory := new(Memory)
 	newMemory.Cbuf = cbuf
-	runtime.SetFinalizer(newMemory, finalizeMemory)
 	newMemory.Size = size
-	newMemory.gobuf = *(*[]byte)(unsafe.Pointer(&newMemory.Cbuf))
+	newMemory.capacity = size
+	newMemory.own()
 	return newMemory
 }
 
+// WrapBorrowed creates a new Memory struct for a block that it doesn't own,
+// such as memory belonging to a C library or static and stack memory. The block
+// is never freed by the Memory, and Grow() fails with ErrNotOwner.
+func WrapBorrowed(cbuf unsafe.Pointer, size uint64) *Memory {
+	newMemory := new(Memory)
+	newMemory.Cbuf = cbuf
+	newMemory.Size = size
+	newMemory.capacity = size
+	newMemory.borrowed = true
+	return newMemory
+}
+
+// destructorBacking releases a block with a function supplied by the user.
+type destructorBacking struct {
+	destructor func(unsafe.Pointer)
+}
+
+// WrapMemoryFunc creates a new Memory struct that takes ownership of a block
+// that must be released with something other than free(), such as
+// sqlite3_free() or av_free(). destructor is called with the block exactly once,
+// from Close() or the finalizer. Grow() fails with ErrNotResizable.
+func WrapMemoryFunc(cbuf unsafe.Pointer, size uint64, destructor func(unsafe.Pointer)) *Memory {
+	newMemory := WrapMemory(cbuf, size)
+	newMemory.backing = &destructorBacking{destructor}
+	return newMemory
+}
+
+func (this *destructorBacking) resize(mem *Memory, size uint64) error {
+	return ErrNotResizable
+}
+
+func (this *destructorBacking) free(mem *Memory) {
+	if this.destructor == nil {
+		return
+	}
+	this.destructor(mem.Cbuf)
+	this.destructor = nil
+}
+
+// Owned returns whether the Memory frees its block when it is closed or
+// garbage collected.
+func (this *Memory) Owned() bool {
+	return !this.borrowed
+}
+
+// Detach hands ownership of the block back to the caller and cancels the
+// finalizer. The caller becomes responsible for releasing the block the same way
+// it was allocated. The Memory stays usable as a borrowed view of the block.
+// Detach returns nil if the Memory is closed, if it doesn't own its block, such
+// as one from WrapBorrowed, Section, or Arena.Alloc, or one that was already
+// detached, or if the block is a mapping from AllocGuarded, AllocSecure,
+// MapFile, or AllocShared, which couldn't be released from Cbuf alone; those
+// are left owned by the Memory.
+func (this *Memory) Detach() unsafe.Pointer {
+	if this.isClosed() || this.borrowed {
+		return nil
+	}
+	if _, ok := this.backing.(*destructorBacking); this.backing != nil && !ok {
+		return nil
+	}
+	runtime.SetFinalizer(this, nil)
+	this.unaccount()
+	this.borrowed = true
+	this.backing = nil
+	return this.Cbuf
+}
+
+// own sets up a newly allocated block to be freed by the finalizer, counts its
+// bytes, and records where it was created for leak reports.
+func (this *Memory) own() {
+	runtime.SetFinalizer(this, finalizeMemory)
+	this.account()
+	this.recordCreation()
+}
+
 func finalizeMemory(deadMemory *Memory) {
-	C.free(deadMemory.Cbuf)
+	reportLeak(deadMemory)
+	deadMemory.release()
 }
 
-// Grow increases the size of the buffer.
+// release frees the block if this object owns it, and marks the Memory closed.
+// Calling it again does nothing.
+func (this *Memory) release() {
+	runtime.SetFinalizer(this, nil)
+	this.closed = true
+	this.unaccount()
+	switch {
+	case this.borrowed:
+	case this.backing != nil:
+		this.backing.free(this)
+		this.backing = nil
+	default:
+		C.free(this.Cbuf)
+	}
+	this.Cbuf = nil
+}
+
+// Grow increases the size of the buffer. If it fails, the old block and its
+// contents are kept. It fails with ErrNotOwner if the block is borrowed, or
+// ErrInUse if it is retained, since moving it would invalidate the pointers
+// held elsewhere.
 func (this *Memory) Grow(size uint64) error {
-	this.Cbuf = C.realloc(this.Cbuf, C.size_t(size))
-	if this.Cbuf == nil {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if this.borrowed {
+		return ErrNotOwner
+	}
+	if this.inUse() {
+		return ErrInUse
+	}
+	if this.backing != nil {
+		err := this.backing.resize(this, size)
+		this.account()
+		if err != nil {
+			return err
+		}
+	} else {
+		err := this.reallocate(size)
+		if err != nil {
+			return err
+		}
+		this.Size = size
+	}
+	if this.cursor > size {
+		this.cursor = size
+	}
+	return nil
+}
+
+// reallocate moves a heap block to one of the given capacity, keeping as much
+// of its contents as fits. On failure the old block is left untouched.
+func (this *Memory) reallocate(capacity uint64) error {
+	// realloc(ptr, 0) may free the block and return NULL, which would look
+	// like a failure.
+	cSize := C.size_t(capacity)
+	if cSize == 0 {
+		cSize = 1
+	}
+	if this.align != 0 {
+		// realloc() doesn't preserve alignment, so move the block by hand.
+		cbuf, err := memalign(uint64(cSize), this.align)
+		if err != nil {
+			return err
+		}
+		copySize := this.Size
+		if capacity < copySize {
+			copySize = capacity
+		}
+		C.memcpy(cbuf, this.Cbuf, C.size_t(copySize))
+		C.free(this.Cbuf)
+		this.Cbuf = cbuf
+		this.capacity = capacity
+		this.account()
+		return nil
+	}
+	cbuf := C.realloc(this.Cbuf, cSize)
+	if cbuf == nil {
 		return errors.New("realloc() could not allocate memory")
 	}
+	this.Cbuf = cbuf
+	this.capacity = capacity
+	this.account()
+	return nil
+}
+
+// SetGrowable switches the Memory in or out of growable mode. In growable mode
+// Size is the length of the data and Capacity() the size of the block, like a
+// bytes.Buffer: Write and WriteByte append past the end instead of returning
+// io.EOF, and the block grows by doubling. Only blocks from the C heap can be
+// growable.
+func (this *Memory) SetGrowable(growable bool) error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if this.borrowed {
+		return ErrNotOwner
+	}
+	if this.backing != nil {
+		return ErrNotResizable
+	}
+	this.growable = growable
+	return nil
+}
+
+// AllocBuffer creates a new growable Memory struct with a length of 0 and room
+// for capacity bytes before it has to grow.
+func AllocBuffer(capacity uint64) (*Memory, error) {
+	newMemory, err := Alloc(capacity)
+	if err != nil {
+		return newMemory, err
+	}
+	newMemory.Size = 0
+	newMemory.growable = true
+	return newMemory, nil
+}
+
+// Capacity returns the number of bytes allocated for the block, which is at
+// least Size.
+func (this *Memory) Capacity() uint64 {
+	return this.capacity
+}
+
+// Reserve makes sure that at least n more bytes can be appended to a growable
+// Memory without reallocating. If it fails, the block and its contents are
+// unchanged.
+func (this *Memory) Reserve(n uint64) error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if !this.growable {
+		return ErrNotGrowable
+	}
+	needed := this.Size + n
+	if needed <= this.capacity {
+		return nil
+	}
+	if this.inUse() {
+		return ErrInUse
+	}
+	newCapacity := this.capacity * 2
+	if newCapacity < needed {
+		newCapacity = needed
+	}
+	return this.reallocate(newCapacity)
+}
+
+// extend lengthens a growable Memory to at least end bytes.
+func (this *Memory) extend(end uint64) error {
+	if end <= this.Size {
+		return nil
+	}
+	err := this.Reserve(end - this.Size)
+	if err != nil {
+		return err
+	}
+	this.Size = end
+	return nil
+}
+
+// Truncate shortens a growable Memory to size bytes, keeping its capacity.
+func (this *Memory) Truncate(size uint64) error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if !this.growable {
+		return ErrNotGrowable
+	}
+	if size > this.Size {
+		return ErrOutOfRange
+	}
 	this.Size = size
-	this.gobuf = *(*[]byte)(unsafe.Pointer(&this.Cbuf))
+	if this.cursor > size {
+		this.cursor = size
+	}
+	return nil
+}
+
+// Reset empties a growable Memory and rewinds it, keeping its capacity.
+func (this *Memory) Reset() error {
+	err := this.Truncate(0)
+	if err != nil {
+		return err
+	}
+	this.cursor = 0
 	return nil
 }
 
+// Alignment returns the alignment the block was allocated with by
+// AllocAligned, or 0 if it came from malloc().
+func (this *Memory) Alignment() uint64 {
+	return this.align
+}
+
+// Bytes returns a byte slice over the whole memory block without copying it.
+// The slice is only valid until the next Grow() or Close(), which can move or
+// free the block; call Bytes() again afterwards. It returns nil after Close().
+func (this *Memory) Bytes() []byte {
+	if this.readable() != nil || this.Cbuf == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(this.Cbuf), this.Size)
+}
+
+// Slice returns a byte slice over length bytes of the memory block starting at
+// offset, without copying them. It is valid for as long as Bytes() is, and
+// fails with ErrOutOfRange if the range doesn't fit in the block.
+func (this *Memory) Slice(offset uint64, length uint64) ([]byte, error) {
+	if err := this.readable(); err != nil {
+		return nil, err
+	}
+	if offset > this.Size || length > this.Size-offset {
+		return nil, ErrOutOfRange
+	}
+	end := offset + length
+	return this.Bytes()[offset:end:end], nil
+}
+
 // Read implements the io.Reader interface to read from the memory block.
 func (this *Memory) Read(output []byte) (int, error) {
+	if err := this.readable(); err != nil {
+		return 0, err
+	}
 	if this.cursor == this.Size {
 		return 0, io.EOF
 	}
@@ -102,22 +472,28 @@ func (this *Memory) Read(output []byte) (int, error) {
 	} else {
 		newCursor = this.cursor + uint64(len(output))
 	}
-	bytesRead := copy(output, this.gobuf[this.cursor:newCursor])
+	bytesRead := copy(output, this.Bytes()[this.cursor:newCursor])
 	this.cursor = newCursor
 	return bytesRead, nil
 }
 
 // ReadByte implements the io.ByteReader interface to read a byte from the memory block.
 func (this *Memory) ReadByte() (byte, error) {
+	if err := this.readable(); err != nil {
+		return 0, err
+	}
 	if this.cursor == this.Size {
 		return 0, io.EOF
 	}
 	this.cursor += 1
-	return this.gobuf[this.cursor-1], nil
+	return this.Bytes()[this.cursor-1], nil
 }
 
 // UnreadByte implements the io.ByteScanner interface to unread a byte from the memory block.
 func (this *Memory) UnreadByte() error {
+	if this.isClosed() {
+		return ErrClosed
+	}
 	if this.cursor == 0 {
 		return io.EOF
 	}
@@ -127,15 +503,34 @@ func (this *Memory) UnreadByte() error {
 
 // ReadAt implements the io.ReaderAt interface to read from the memory block at an offset.
 func (this *Memory) ReadAt(output []byte, offset int64) (int, error) {
+	if err := this.readable(); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
 	if offset >= int64(this.Size) {
 		return 0, io.EOF
 	}
-	return copy(output, this.gobuf[offset:this.Size]), nil
+	bytesRead := copy(output, this.Bytes()[offset:this.Size])
+	if bytesRead < len(output) {
+		return bytesRead, io.EOF
+	}
+	return bytesRead, nil
 }
 
-// Write implements the io.Writer interface to write to the memory block.
+// Write implements the io.Writer interface to write to the memory block. A
+// growable block is extended to fit the whole input.
 func (this *Memory) Write(input []byte) (int, error) {
-	if this.cursor == this.Size {
+	if err := this.writable(); err != nil {
+		return 0, err
+	}
+	if this.growable {
+		err := this.extend(this.cursor + uint64(len(input)))
+		if err != nil {
+			return 0, err
+		}
+	} else if this.cursor == this.Size {
 		return 0, io.EOF
 	}
 	var newCursor uint64
@@ -144,31 +539,48 @@ func (this *Memory) Write(input []byte) (int, error) {
 	} else {
 		newCursor = this.cursor + uint64(len(input))
 	}
-	bytesWritten := copy(this.gobuf[this.cursor:newCursor], input)
+	bytesWritten := copy(this.Bytes()[this.cursor:newCursor], input)
 	this.cursor = newCursor
 	return bytesWritten, nil
 }
 
 // WriteByte implements the io.ByteWriter interface to write a byte to the memory block.
 func (this *Memory) WriteByte(input byte) error {
-	if this.cursor == this.Size {
+	if err := this.writable(); err != nil {
+		return err
+	}
+	if this.growable {
+		err := this.extend(this.cursor + 1)
+		if err != nil {
+			return err
+		}
+	} else if this.cursor == this.Size {
 		return io.EOF
 	}
-	this.gobuf[this.cursor] = input
+	this.Bytes()[this.cursor] = input
 	this.cursor += 1
 	return nil
 }
 
 // WriteAt implements the io.WriterAt interface to write to the memory block at an offset.
 func (this *Memory) WriteAt(input []byte, offset int64) (int, error) {
+	if err := this.writable(); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
 	if offset >= int64(this.Size) {
 		return 0, io.EOF
 	}
-	return copy(this.gobuf[offset:this.Size], input), nil
+	return copy(this.Bytes()[offset:this.Size], input), nil
 }
 
 // Seek implements the io.Seeker interface to seek through the memory block.
 func (this *Memory) Seek(offset int64, whence int) (int64, error) {
+	if this.isClosed() {
+		return 0, ErrClosed
+	}
 	var newCursor int64
 	switch {
 	case whence == 0:
@@ -190,10 +602,70 @@ func (this *Memory) Seek(offset int64, whence int) (int64, error) {
 	return int64(this.cursor), nil
 }
 
-// Close implements the io.Closer interface to free the memory block. Any method call on this object after Close() is undefined.
+// isClosed returns whether this Memory, or the Memory it is a section of, has
+// been closed. A section that no longer fits in its parent counts as closed.
+func (this *Memory) isClosed() bool {
+	if this.parent == nil {
+		return this.closed
+	}
+	return this.closed || !this.follow()
+}
+
+// readable returns ErrClosed if the block has been closed, or ErrNoAccess if
+// reading it would fault.
+func (this *Memory) readable() error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if this.owner().noAccess {
+		return ErrNoAccess
+	}
+	return nil
+}
+
+// writable returns ErrClosed if the block has been closed, or ErrReadOnly if
+// it, or the Memory it is a section of, can't be written to.
+func (this *Memory) writable() error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	for mem := this; mem != nil; mem = mem.parent {
+		if mem.readOnly {
+			return ErrReadOnly
+		}
+	}
+	return nil
+}
+
+// follow points a section at its parent's block again, in case Grow() moved
+// it. It returns false if the parent is closed or too small for the section.
+func (this *Memory) follow() bool {
+	if this.parent.isClosed() || this.offset+this.Size > this.parent.Size {
+		return false
+	}
+	this.Cbuf = unsafe.Add(this.parent.Cbuf, this.offset)
+	return true
+}
+
+// Close implements the io.Closer interface to free the memory block and cancel
+// the finalizer. Borrowed blocks are left alone, and retained blocks are freed
+// by the last Release() instead. Calling Close() again does nothing, and the
+// other methods return ErrClosed afterwards.
 func (this *Memory) Close() error {
-	C.free(this.Cbuf)
-	this.Cbuf = nil
+	if this.closed {
+		return nil
+	}
+	runtime.SetFinalizer(this, nil)
+	retainMutex.Lock()
+	this.closed = true
+	inUse := retained[this] != 0
+	retainMutex.Unlock()
+	this.cursor = 0
+	if inUse {
+		// The last Release() frees the block.
+		return nil
+	}
+	this.release()
 	return nil
 }
 
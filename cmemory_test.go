@@ -7,6 +7,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"unsafe"
 )
 
 func TestAlloc(t *testing.T) {
@@ -278,6 +279,64 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestCloseTwice(t *testing.T) {
+	block := testMalloc(256)
+	releases := 0
+	mem := WrapMemoryFunc(block, 256, func(cbuf unsafe.Pointer) {
+		releases += 1
+		WrapMemory(cbuf, 256).Close()
+	})
+	if mem.Close() != nil {
+		t.Error("Close() failed")
+	}
+	if mem.Close() != nil {
+		t.Error("Close() failed when called twice")
+	}
+	finalizeMemory(mem)
+	if releases != 1 {
+		t.Error("Close() released the block more than once")
+	}
+}
+
+func TestErrClosed(t *testing.T) {
+	mem, _ := Alloc(256)
+	mem.Close()
+	buf := make([]byte, 16)
+	if _, err := mem.Read(buf); err != ErrClosed {
+		t.Error("Read() did not return ErrClosed")
+	}
+	if _, err := mem.ReadByte(); err != ErrClosed {
+		t.Error("ReadByte() did not return ErrClosed")
+	}
+	if err := mem.UnreadByte(); err != ErrClosed {
+		t.Error("UnreadByte() did not return ErrClosed")
+	}
+	if _, err := mem.ReadAt(buf, 0); err != ErrClosed {
+		t.Error("ReadAt() did not return ErrClosed")
+	}
+	if _, err := mem.Write(buf); err != ErrClosed {
+		t.Error("Write() did not return ErrClosed")
+	}
+	if err := mem.WriteByte(0); err != ErrClosed {
+		t.Error("WriteByte() did not return ErrClosed")
+	}
+	if _, err := mem.WriteAt(buf, 0); err != ErrClosed {
+		t.Error("WriteAt() did not return ErrClosed")
+	}
+	if _, err := mem.Seek(0, 0); err != ErrClosed {
+		t.Error("Seek() did not return ErrClosed")
+	}
+	if err := mem.Grow(512); err != ErrClosed {
+		t.Error("Grow() did not return ErrClosed")
+	}
+	if err := mem.Sync(); err != ErrClosed {
+		t.Error("Sync() did not return ErrClosed")
+	}
+	if mem.Detach() != nil {
+		t.Error("Detach() returned a closed block")
+	}
+}
+
 func TestInstrumentation(t *testing.T) {
 	ResetInstrumentation()
 	StartInstrumentation()
@@ -386,3 +445,212 @@ func TestLeaks(t *testing.T) {
 		t.Error("MemoryBlocks() printed incorrect results")
 	}
 }
+
+func TestAllocAligned(t *testing.T) {
+	mem, err := AllocAligned(100, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uintptr(mem.Cbuf)%4096 != 0 {
+		t.Error("AllocAligned() returned a misaligned block")
+	}
+	if mem.Alignment() != 4096 {
+		t.Error("AllocAligned() did not record the alignment")
+	}
+	*(*uint64)(mem.Cbuf) = 0xdeadbeef
+	err = mem.Grow(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uintptr(mem.Cbuf)%4096 != 0 {
+		t.Error("Grow() lost the alignment")
+	}
+	if *(*uint64)(mem.Cbuf) != 0xdeadbeef {
+		t.Error("Grow() did not keep the contents")
+	}
+	mem.Close()
+	_, err = AllocAligned(100, 48)
+	if err != ErrInvalidAlignment {
+		t.Error("AllocAligned() accepted an invalid alignment")
+	}
+}
+
+func TestWrapBorrowed(t *testing.T) {
+	block := testMalloc(256)
+	mem := WrapBorrowed(block, 256)
+	if mem.Owned() {
+		t.Error("WrapBorrowed() took ownership of the block")
+	}
+	if mem.Grow(512) != ErrNotOwner {
+		t.Error("Grow() moved a borrowed block")
+	}
+	mem.Close()
+	finalizeMemory(mem)
+	// Freeing the block here would be a double free if Close() had freed it.
+	WrapMemory(block, 256).Close()
+}
+
+func TestWrapMemoryFunc(t *testing.T) {
+	block := testMalloc(256)
+	var released []unsafe.Pointer
+	mem := WrapMemoryFunc(block, 256, func(cbuf unsafe.Pointer) {
+		released = append(released, cbuf)
+		WrapMemory(cbuf, 256).Close()
+	})
+	if !mem.Owned() {
+		t.Error("WrapMemoryFunc() did not take ownership of the block")
+	}
+	if mem.Grow(512) != ErrNotResizable {
+		t.Error("Grow() resized a block with a custom destructor")
+	}
+	mem.Close()
+	finalizeMemory(mem)
+	if len(released) != 1 || released[0] != block {
+		t.Error("WrapMemoryFunc() did not call the destructor exactly once")
+	}
+}
+
+func TestDetach(t *testing.T) {
+	block := testMalloc(256)
+	released := false
+	mem := WrapMemoryFunc(block, 256, func(cbuf unsafe.Pointer) {
+		released = true
+	})
+	if mem.Detach() != block {
+		t.Error("Detach() returned the wrong block")
+	}
+	if mem.Owned() {
+		t.Error("Detach() did not give up ownership")
+	}
+	mem.Close()
+	finalizeMemory(mem)
+	if released {
+		t.Error("Close() released a detached block")
+	}
+	WrapMemory(block, 256).Close()
+
+	guarded, _ := AllocGuarded(64)
+	defer guarded.Close()
+	if guarded.Detach() != nil || !guarded.Owned() {
+		t.Error("Detach() gave up a guarded mapping")
+	}
+	if WrapBorrowed(guarded.Cbuf, 8).Detach() != nil {
+		t.Error("Detach() returned a borrowed block")
+	}
+	section, _ := guarded.Section(8, 8)
+	if section.Detach() != nil {
+		t.Error("Detach() returned a section")
+	}
+}
+
+func TestBytes(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	data := mem.Bytes()
+	if len(data) != 256 || cap(data) != 256 {
+		t.Error("Bytes() returned a slice of the wrong size")
+	}
+	if !bytes.Equal(data, initTestData()) {
+		t.Error("Bytes() returned the wrong data")
+	}
+	data[0] = 0xff
+	if *(*byte)(mem.Cbuf) != 0xff {
+		t.Error("Bytes() returned a copy of the block")
+	}
+	mem.Grow(1024)
+	if len(mem.Bytes()) != 1024 {
+		t.Error("Bytes() did not follow Grow()")
+	}
+	mem.Close()
+	if mem.Bytes() != nil {
+		t.Error("Bytes() returned a slice after Close()")
+	}
+}
+
+func TestSlice(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	part, err := mem.Slice(16, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(part) != 32 || cap(part) != 32 || part[0] != 16 {
+		t.Error("Slice() returned the wrong range")
+	}
+	if _, err := mem.Slice(250, 7); err != ErrOutOfRange {
+		t.Error("Slice() accepted a range past the end of the block")
+	}
+	if _, err := mem.Slice(256, 0); err != nil {
+		t.Error("Slice() rejected an empty range at the end of the block")
+	}
+}
+
+func TestAllocBuffer(t *testing.T) {
+	mem, err := AllocBuffer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Size != 0 || mem.Capacity() != 4 {
+		t.Error("AllocBuffer() set the wrong length or capacity")
+	}
+	testData := initTestData()
+	for _, oneByte := range testData[:10] {
+		if err := mem.WriteByte(oneByte); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mem.Capacity() != 16 {
+		t.Error("WriteByte() did not double the capacity")
+	}
+	bytesWritten, err := mem.Write(testData[10:])
+	if err != nil || bytesWritten != 246 {
+		t.Error("Write() did not append the whole input")
+	}
+	if mem.Size != 256 || !bytes.Equal(mem.Bytes(), testData) {
+		t.Error("Write() appended the wrong data")
+	}
+	if _, err := mem.Write(nil); err != nil {
+		t.Error("Write() returned an error for empty input")
+	}
+}
+
+func TestReserveTruncateReset(t *testing.T) {
+	mem, _ := AllocBuffer(0)
+	if err := mem.Reserve(100); err != nil {
+		t.Fatal(err)
+	}
+	if mem.Capacity() < 100 || mem.Size != 0 {
+		t.Error("Reserve() did not make room")
+	}
+	mem.Write(initTestData()[:50])
+	if err := mem.Truncate(20); err != nil {
+		t.Error(err)
+	}
+	if mem.Size != 20 || mem.cursor != 20 {
+		t.Error("Truncate() did not shorten the block")
+	}
+	if mem.Truncate(21) != ErrOutOfRange {
+		t.Error("Truncate() lengthened the block")
+	}
+	capacity := mem.Capacity()
+	mem.Reset()
+	if mem.Size != 0 || mem.cursor != 0 || mem.Capacity() != capacity {
+		t.Error("Reset() did not empty the block")
+	}
+	fixed, _ := Alloc(16)
+	if fixed.Reserve(16) != ErrNotGrowable {
+		t.Error("Reserve() worked on a block that isn't growable")
+	}
+	borrowed := WrapBorrowed(fixed.Cbuf, 16)
+	if borrowed.SetGrowable(true) != ErrNotOwner {
+		t.Error("SetGrowable() accepted a borrowed block")
+	}
+}
+
+func TestGrowFailure(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	if mem.Grow(1<<62) == nil {
+		t.Skip("realloc() did not fail")
+	}
+	if mem.Cbuf == nil || mem.Size != 256 || !bytes.Equal(mem.Bytes(), initTestData()) {
+		t.Error("Grow() lost the block when it failed")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+/*
+#include <wchar.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// AllocCString creates a new Memory struct holding s as a NUL-terminated C
+// string, ready to be passed as a char*. Size includes the terminator.
+func AllocCString(s string) (*Memory, error) {
+	newMemory, err := Alloc(uint64(len(s)) + 1)
+	if err != nil {
+		return newMemory, err
+	}
+	data := newMemory.Bytes()
+	copy(data, s)
+	data[len(s)] = 0
+	return newMemory, nil
+}
+
+// AllocCStrings creates a new Memory struct holding a NULL-terminated array of
+// char* followed by the NUL-terminated strings it points to, so that Cbuf can
+// be passed as a char** such as argv. Everything is freed together.
+func AllocCStrings(strs []string) (*Memory, error) {
+	tableSize := uint64(len(strs)+1) * sizeofPointer
+	size := tableSize
+	for _, s := range strs {
+		size += uint64(len(s)) + 1
+	}
+	newMemory, err := Alloc(size)
+	if err != nil {
+		return newMemory, err
+	}
+	data := newMemory.Bytes()
+	table := unsafe.Slice((*unsafe.Pointer)(newMemory.Cbuf), len(strs)+1)
+	offset := tableSize
+	for index, s := range strs {
+		table[index] = unsafe.Add(newMemory.Cbuf, offset)
+		copy(data[offset:], s)
+		offset += uint64(len(s))
+		data[offset] = 0
+		offset += 1
+	}
+	table[len(strs)] = nil
+	return newMemory, nil
+}
+
+// CStringAt returns the NUL-terminated string starting at offset. It returns
+// ErrOutOfRange if there is no terminator before the end of the block.
+func (this *Memory) CStringAt(offset uint64) (string, error) {
+	if err := this.readable(); err != nil {
+		return "", err
+	}
+	if offset >= this.Size {
+		return "", ErrOutOfRange
+	}
+	data := this.Bytes()[offset:]
+	for index, oneByte := range data {
+		if oneByte == 0 {
+			return string(data[:index]), nil
+		}
+	}
+	return "", ErrOutOfRange
+}
+
+// CStringsAt walks the NULL-terminated array of char* starting at offset and
+// returns the strings it points to. The pointers can point anywhere in C
+// memory, but the array itself has to end inside the block.
+func (this *Memory) CStringsAt(offset uint64) ([]string, error) {
+	strs := make([]string, 0)
+	for {
+		pointer, err := this.PointerAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		if pointer == nil {
+			return strs, nil
+		}
+		strs = append(strs, C.GoString((*C.char)(pointer)))
+		offset += sizeofPointer
+	}
+}
+
+// AllocUTF16String creates a new Memory struct holding s encoded as
+// NUL-terminated UTF-16 in native byte order, for libraries that take char16_t
+// or Windows-style wide strings.
+func AllocUTF16String(s string) (*Memory, error) {
+	units := utf16.Encode([]rune(s))
+	newMemory, err := Alloc(uint64(len(units)+1) * 2)
+	if err != nil {
+		return newMemory, err
+	}
+	data := newMemory.Bytes()
+	for index, unit := range units {
+		binary.NativeEndian.PutUint16(data[index*2:], unit)
+	}
+	binary.NativeEndian.PutUint16(data[len(units)*2:], 0)
+	return newMemory, nil
+}
+
+// UTF16StringAt decodes the NUL-terminated UTF-16 string starting at offset.
+// It returns ErrOutOfRange if there is no terminator before the end of the
+// block.
+func (this *Memory) UTF16StringAt(offset uint64) (string, error) {
+	units := make([]uint16, 0)
+	for {
+		unit, err := this.Uint16At(offset, binary.NativeEndian)
+		if err != nil {
+			return "", err
+		}
+		if unit == 0 {
+			return string(utf16.Decode(units)), nil
+		}
+		units = append(units, unit)
+		offset += 2
+	}
+}
+
+// AllocWideString creates a new Memory struct holding s as a NUL-terminated
+// wchar_t string. wchar_t is UTF-32 where it is 4 bytes wide, as with glibc,
+// and UTF-16 where it is 2 bytes wide.
+func AllocWideString(s string) (*Memory, error) {
+	if C.sizeof_wchar_t == 2 {
+		return AllocUTF16String(s)
+	}
+	runes := []rune(s)
+	newMemory, err := Alloc(uint64(len(runes)+1) * 4)
+	if err != nil {
+		return newMemory, err
+	}
+	data := newMemory.Bytes()
+	for index, character := range runes {
+		binary.NativeEndian.PutUint32(data[index*4:], uint32(character))
+	}
+	binary.NativeEndian.PutUint32(data[len(runes)*4:], 0)
+	return newMemory, nil
+}
+
+// WideStringAt decodes the NUL-terminated wchar_t string starting at offset.
+// It returns ErrOutOfRange if there is no terminator before the end of the
+// block.
+func (this *Memory) WideStringAt(offset uint64) (string, error) {
+	if C.sizeof_wchar_t == 2 {
+		return this.UTF16StringAt(offset)
+	}
+	runes := make([]rune, 0)
+	for {
+		character, err := this.Uint32At(offset, binary.NativeEndian)
+		if err != nil {
+			return "", err
+		}
+		if character == 0 {
+			return string(runes), nil
+		}
+		runes = append(runes, rune(character))
+		offset += 4
+	}
+}
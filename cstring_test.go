@@ -0,0 +1,84 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestAllocCString(t *testing.T) {
+	mem, err := AllocCString("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Size != 6 || testStrlen(mem.Cbuf) != 5 {
+		t.Error("AllocCString() did not terminate the string")
+	}
+	s, err := mem.CStringAt(1)
+	if err != nil || s != "ello" {
+		t.Error("CStringAt() returned the wrong string")
+	}
+	unterminated, _ := AllocFromSlice([]byte("abc"))
+	if _, err := unterminated.CStringAt(0); err != ErrOutOfRange {
+		t.Error("CStringAt() read past the end of the block")
+	}
+}
+
+func TestAllocCStrings(t *testing.T) {
+	args := []string{"ls", "-l", "", "/tmp"}
+	mem, err := AllocCStrings(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argv := unsafe.Slice((*unsafe.Pointer)(mem.Cbuf), 5)
+	if argv[4] != nil {
+		t.Error("AllocCStrings() did not terminate the array")
+	}
+	if testStrlen(argv[3]) != 4 {
+		t.Error("AllocCStrings() did not terminate the strings")
+	}
+	strs, err := mem.CStringsAt(0)
+	if err != nil || !reflect.DeepEqual(strs, args) {
+		t.Error("CStringsAt() returned the wrong strings")
+	}
+	empty, _ := AllocCStrings(nil)
+	strs, err = empty.CStringsAt(0)
+	if err != nil || len(strs) != 0 {
+		t.Error("CStringsAt() failed on an empty array")
+	}
+	if _, err := mem.CStringsAt(mem.Size - 4); err != ErrOutOfRange {
+		t.Error("CStringsAt() read past the end of the block")
+	}
+}
+
+func TestWideStrings(t *testing.T) {
+	text := "Grüße, 世界 😀"
+	utf16Mem, err := AllocUTF16String(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utf16Mem.Size != 26 {
+		t.Error("AllocUTF16String() used the wrong number of code units")
+	}
+	s, err := utf16Mem.UTF16StringAt(0)
+	if err != nil || s != text {
+		t.Error("UTF16StringAt() returned the wrong string")
+	}
+	wide, err := AllocWideString(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testWcslen(wide.Cbuf) != 11 {
+		t.Error("AllocWideString() did not produce a valid wchar_t string")
+	}
+	s, err = wide.WideStringAt(0)
+	if err != nil || s != text {
+		t.Error("WideStringAt() returned the wrong string")
+	}
+	unterminated, _ := AllocFromSlice([]byte{'a', 0, 'b'})
+	if _, err := unterminated.UTF16StringAt(0); err != ErrOutOfRange {
+		t.Error("UTF16StringAt() read past the end of the block")
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+var ErrNotStruct = errors.New("Value must be a struct or a pointer to one")
+
+// cType describes how a Go type is laid out in C.
+type cType struct {
+	size  uint64
+	align uint64
+	kind  reflect.Kind
+	// elem and length describe arrays, and length the char array behind a
+	// string.
+	elem   *cType
+	length uint64
+	fields []cField
+}
+
+// cField is a field of a C struct.
+type cField struct {
+	index  int
+	offset uint64
+	ctype  *cType
+	// padding is set for blank fields, which take up space but aren't
+	// encoded.
+	padding bool
+}
+
+var structLayouts sync.Map
+
+// structLayout returns the C layout of a struct type, computing it the first
+// time.
+func structLayout(structType reflect.Type) (*cType, error) {
+	if layout, ok := structLayouts.Load(structType); ok {
+		return layout.(*cType), nil
+	}
+	layout := &cType{kind: reflect.Struct, align: 1}
+	packed := false
+	for i := 0; i < structType.NumField(); i++ {
+		if structType.Field(i).Name == "_" && structType.Field(i).Tag.Get("cstruct") == "packed" {
+			packed = true
+		}
+	}
+	var end uint64
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		options := strings.Split(field.Tag.Get("cstruct"), ",")
+		if options[0] == "-" || (!field.IsExported() && field.Name != "_") {
+			continue
+		}
+		var length, offset uint64
+		explicitOffset := false
+		for _, option := range options {
+			var err error
+			switch {
+			case option == "":
+			case strings.HasPrefix(option, "len="):
+				length, err = strconv.ParseUint(option[4:], 10, 64)
+			case strings.HasPrefix(option, "offset="):
+				offset, err = strconv.ParseUint(option[7:], 10, 64)
+				explicitOffset = true
+			case option == "packed":
+				if field.Name != "_" {
+					err = errors.New("packed only goes on a blank field")
+				}
+			default:
+				err = fmt.Errorf("unknown option %q", option)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Field %s has an invalid tag: %v", field.Name, err)
+			}
+		}
+		fieldType, err := cTypeOf(field.Type, length)
+		if err != nil {
+			return nil, fmt.Errorf("Field %s: %v", field.Name, err)
+		}
+		align := fieldType.align
+		if packed {
+			align = 1
+		}
+		if !explicitOffset {
+			offset = uint64(alignUp(uintptr(end), align))
+		}
+		if offset+fieldType.size > end {
+			end = offset + fieldType.size
+		}
+		if align > layout.align {
+			layout.align = align
+		}
+		layout.fields = append(layout.fields, cField{i, offset, fieldType, field.Name == "_"})
+	}
+	layout.size = uint64(alignUp(uintptr(end), layout.align))
+	structLayouts.Store(structType, layout)
+	return layout, nil
+}
+
+// cTypeOf returns the C layout of a Go type. length is the size of the char
+// array for strings.
+func cTypeOf(goType reflect.Type, length uint64) (*cType, error) {
+	switch goType.Kind() {
+	case reflect.Int, reflect.Uint:
+		// Go's int is as wide as C's long, not C's int, so it's too easy to
+		// get wrong.
+		return nil, fmt.Errorf("type %s has no fixed C size, use a sized integer type", goType)
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.UnsafePointer:
+		return &cType{size: uint64(goType.Size()), align: uint64(goType.Align()), kind: goType.Kind()}, nil
+	case reflect.String:
+		if length == 0 {
+			return nil, errors.New("strings need a len= tag")
+		}
+		return &cType{size: length, align: 1, kind: reflect.String, length: length}, nil
+	case reflect.Array:
+		elem, err := cTypeOf(goType.Elem(), 0)
+		if err != nil {
+			return nil, err
+		}
+		length := uint64(goType.Len())
+		return &cType{size: elem.size * length, align: elem.align, kind: reflect.Array, elem: elem, length: length}, nil
+	case reflect.Struct:
+		return structLayout(goType)
+	}
+	return nil, fmt.Errorf("type %s has no C equivalent", goType)
+}
+
+// structValue returns the struct that v is or points to.
+func structValue(v any) (reflect.Value, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}, ErrNotStruct
+	}
+	return value, nil
+}
+
+// SizeOf returns the size of the C struct that v is laid out as by Marshal.
+func SizeOf(v any) (uint64, error) {
+	value, err := structValue(v)
+	if err != nil {
+		return 0, err
+	}
+	layout, err := structLayout(value.Type())
+	if err != nil {
+		return 0, err
+	}
+	return layout.size, nil
+}
+
+// Marshal writes the struct v, or the struct it points to, to the start of mem
+// using the C layout of the struct: fields are aligned to their natural
+// alignment and the struct is padded to a multiple of its largest field's
+// alignment. Sized integers, uintptr, floats, bools, unsafe.Pointer, arrays,
+// and nested structs are supported, in native byte order; int and uint are
+// rejected since their size doesn't match C's int. Field tags change the
+// layout, and unknown tag options are an error:
+//
+//	Name  string   `cstruct:"len=16"`   // a NUL-padded char[16]
+//	Flags uint32   `cstruct:"offset=8"` // placed at byte 8
+//	Skip  int      `cstruct:"-"`        // not part of the struct
+//	_     struct{} `cstruct:"packed"`   // no padding in this struct
+//
+// Blank fields take up space but are never written, and unexported fields are
+// ignored. Padding bytes in mem are left as they are.
+func Marshal(v any, mem *Memory) error {
+	value, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	layout, err := structLayout(value.Type())
+	if err != nil {
+		return err
+	}
+	data, err := mem.Slice(0, layout.size)
+	if err != nil {
+		return err
+	}
+	if err := mem.writable(); err != nil {
+		return err
+	}
+	return encodeValue(data, value, layout)
+}
+
+// Unmarshal reads the C struct at the start of mem into the struct that v
+// points to, using the same layout as Marshal.
+func Unmarshal(mem *Memory, v any) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	value = value.Elem()
+	layout, err := structLayout(value.Type())
+	if err != nil {
+		return err
+	}
+	data, err := mem.Slice(0, layout.size)
+	if err != nil {
+		return err
+	}
+	decodeValue(data, value, layout)
+	return nil
+}
+
+func encodeValue(data []byte, value reflect.Value, ctype *cType) error {
+	switch ctype.kind {
+	case reflect.Bool:
+		data[0] = 0
+		if value.Bool() {
+			data[0] = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		putUint(data, ctype.size, uint64(value.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		putUint(data, ctype.size, value.Uint())
+	case reflect.Float32:
+		binary.NativeEndian.PutUint32(data, math.Float32bits(float32(value.Float())))
+	case reflect.Float64:
+		binary.NativeEndian.PutUint64(data, math.Float64bits(value.Float()))
+	case reflect.UnsafePointer:
+		*(*unsafe.Pointer)(unsafe.Pointer(&data[0])) = value.UnsafePointer()
+	case reflect.String:
+		if uint64(value.Len()) > ctype.length {
+			return fmt.Errorf("String %q does not fit in char[%d]", value.String(), ctype.length)
+		}
+		clear(data[copy(data[:ctype.length], value.String()):ctype.length])
+	case reflect.Array:
+		for i := uint64(0); i < ctype.length; i++ {
+			err := encodeValue(data[i*ctype.elem.size:], value.Index(int(i)), ctype.elem)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for _, field := range ctype.fields {
+			if field.padding {
+				continue
+			}
+			err := encodeValue(data[field.offset:], value.Field(field.index), field.ctype)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeValue(data []byte, value reflect.Value, ctype *cType) {
+	switch ctype.kind {
+	case reflect.Bool:
+		value.SetBool(data[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(signExtend(getUint(data, ctype.size), ctype.size))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value.SetUint(getUint(data, ctype.size))
+	case reflect.Float32:
+		value.SetFloat(float64(math.Float32frombits(binary.NativeEndian.Uint32(data))))
+	case reflect.Float64:
+		value.SetFloat(math.Float64frombits(binary.NativeEndian.Uint64(data)))
+	case reflect.UnsafePointer:
+		value.SetPointer(*(*unsafe.Pointer)(unsafe.Pointer(&data[0])))
+	case reflect.String:
+		chars := data[:ctype.length]
+		for index, char := range chars {
+			if char == 0 {
+				chars = chars[:index]
+				break
+			}
+		}
+		value.SetString(string(chars))
+	case reflect.Array:
+		for i := uint64(0); i < ctype.length; i++ {
+			decodeValue(data[i*ctype.elem.size:], value.Index(int(i)), ctype.elem)
+		}
+	case reflect.Struct:
+		for _, field := range ctype.fields {
+			if !field.padding {
+				decodeValue(data[field.offset:], value.Field(field.index), field.ctype)
+			}
+		}
+	}
+}
+
+// putUint stores the low size bytes of value in native byte order.
+func putUint(data []byte, size uint64, value uint64) {
+	switch size {
+	case 1:
+		data[0] = byte(value)
+	case 2:
+		binary.NativeEndian.PutUint16(data, uint16(value))
+	case 4:
+		binary.NativeEndian.PutUint32(data, uint32(value))
+	case 8:
+		binary.NativeEndian.PutUint64(data, value)
+	}
+}
+
+// getUint loads a size byte integer in native byte order.
+func getUint(data []byte, size uint64) uint64 {
+	switch size {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(binary.NativeEndian.Uint16(data))
+	case 4:
+		return uint64(binary.NativeEndian.Uint32(data))
+	}
+	return binary.NativeEndian.Uint64(data)
+}
+
+// signExtend interprets the low size bytes of value as a signed integer.
+func signExtend(value uint64, size uint64) int64 {
+	shift := 64 - 8*size
+	return int64(value<<shift) >> shift
+}
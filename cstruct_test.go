@@ -0,0 +1,134 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+type testInner struct {
+	A uint8
+	B uint64
+}
+
+type testStruct struct {
+	Tag   byte
+	ID    int32
+	Value float64
+	Name  string `cstruct:"len=10"`
+	Port  int16
+	Inner testInner
+	Ptr   unsafe.Pointer
+	Extra int `cstruct:"-"`
+}
+
+type testPacked struct {
+	_ struct{} `cstruct:"packed"`
+	A byte
+	B int32
+	C int16
+}
+
+func TestStructLayout(t *testing.T) {
+	layout, err := structLayout(reflect.TypeOf(testStruct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := testStructLayout()
+	if layout.size != expected[0] {
+		t.Errorf("Layout size is %d, C says %d", layout.size, expected[0])
+	}
+	for index, field := range layout.fields[1:] {
+		if field.offset != expected[index+1] {
+			t.Errorf("Field %d is at offset %d, C says %d", index+1, field.offset, expected[index+1])
+		}
+	}
+	size, _ := SizeOf(testPacked{})
+	if size != testPackedSize() {
+		t.Errorf("Packed size is %d, C says %d", size, testPackedSize())
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	mem, _ := Alloc(testStructLayout()[0])
+	value := testStruct{Tag: 'x', ID: -7, Port: -2, Name: "gopher"}
+	if err := Marshal(&value, mem); err != nil {
+		t.Fatal(err)
+	}
+	id, port := testStructFields(mem.Cbuf)
+	if id != -7 || port != -2 {
+		t.Error("Marshal() wrote fields where C doesn't expect them")
+	}
+	value.Name = "much too long"
+	if Marshal(value, mem) == nil {
+		t.Error("Marshal() accepted a string longer than its char array")
+	}
+	small, _ := Alloc(8)
+	if Marshal(value, small) != ErrOutOfRange {
+		t.Error("Marshal() wrote past the end of the block")
+	}
+	if Marshal(42, mem) != ErrNotStruct {
+		t.Error("Marshal() accepted a value that isn't a struct")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	mem, _ := Alloc(testStructLayout()[0])
+	testFillStruct(mem.Cbuf)
+	var value testStruct
+	if err := Unmarshal(mem, &value); err != nil {
+		t.Fatal(err)
+	}
+	expected := testStruct{Tag: 'x', ID: -42, Value: 2.5, Name: "gopher", Port: 8080, Inner: testInner{7, 1 << 40}, Ptr: mem.Cbuf}
+	if value != expected {
+		t.Errorf("Unmarshal() = %+v, want %+v", value, expected)
+	}
+	if Unmarshal(mem, value) != ErrNotStruct {
+		t.Error("Unmarshal() accepted a struct that isn't a pointer")
+	}
+}
+
+func TestStructTags(t *testing.T) {
+	type explicit struct {
+		A uint8
+		B uint32 `cstruct:"offset=12"`
+		_ [4]byte
+		C uint8
+	}
+	size, err := SizeOf(explicit{})
+	if err != nil || size != 24 {
+		t.Errorf("SizeOf() = %d, %v, want 24", size, err)
+	}
+	type unsupported struct {
+		M map[int]int
+	}
+	if _, err := SizeOf(unsupported{}); err == nil {
+		t.Error("SizeOf() accepted a map field")
+	}
+	type noLength struct {
+		S string
+	}
+	if _, err := SizeOf(noLength{}); err == nil {
+		t.Error("SizeOf() accepted a string without a length")
+	}
+	type plainInt struct {
+		Flags int
+	}
+	if _, err := SizeOf(plainInt{}); err == nil {
+		t.Error("SizeOf() accepted an int field")
+	}
+	type misspelled struct {
+		S string `cstruct:"length=8"`
+	}
+	if _, err := SizeOf(misspelled{}); err == nil {
+		t.Error("SizeOf() accepted an unknown tag option")
+	}
+	type packedField struct {
+		A uint32 `cstruct:"packed"`
+	}
+	if _, err := SizeOf(packedField{}); err == nil {
+		t.Error("SizeOf() accepted packed on a named field")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+/*
+#include <string.h>
+#include <sys/mman.h>
+#include <sys/types.h>
+
+void* map_pages(size_t length, int prot, int flags, int fd, off_t offset);
+*/
+import "C"
+
+import (
+	"os"
+	"unsafe"
+)
+
+// guardBacking is an anonymous mapping with an inaccessible page on each side
+// of the block.
+type guardBacking struct {
+	base   unsafe.Pointer
+	length uint64
+}
+
+// AllocGuarded creates a new Memory struct backed by its own mmap()ed pages,
+// with a PROT_NONE guard page directly before and after them. The end of the
+// block is placed against the trailing guard page, so writing even one byte
+// past Size faults immediately instead of corrupting the heap, in the style of
+// Electric Fence. Underflows are caught once they pass the start of the first
+// page. Because of the placement, Cbuf is only as aligned as Size is.
+func AllocGuarded(size uint64) (*Memory, error) {
+	newMemory := new(Memory)
+	cbuf, backing, err := mapGuarded(size)
+	if err != nil {
+		return newMemory, err
+	}
+	newMemory.Cbuf = cbuf
+	newMemory.Size = size
+	newMemory.capacity = size
+	newMemory.backing = backing
+	newMemory.own()
+	return newMemory, nil
+}
+
+// mapGuarded maps enough pages for size bytes plus the two guard pages, and
+// returns a pointer to the first byte of the block.
+func mapGuarded(size uint64) (unsafe.Pointer, *guardBacking, error) {
+	pageSize := uint64(os.Getpagesize())
+	dataLength := (size + pageSize - 1) / pageSize * pageSize
+	length := dataLength + 2*pageSize
+	base, err := C.map_pages(C.size_t(length), C.PROT_NONE, C.MAP_PRIVATE|C.MAP_ANONYMOUS, -1, 0)
+	if base == nil {
+		return nil, nil, err
+	}
+	data := unsafe.Add(base, pageSize)
+	if dataLength > 0 {
+		_, err = C.mprotect(data, C.size_t(dataLength), C.PROT_READ|C.PROT_WRITE)
+		if err != nil {
+			C.munmap(base, C.size_t(length))
+			return nil, nil, err
+		}
+	}
+	return unsafe.Add(data, dataLength-size), &guardBacking{base, length}, nil
+}
+
+func (this *guardBacking) resize(mem *Memory, size uint64) error {
+	cbuf, newBacking, err := mapGuarded(size)
+	if err != nil {
+		return err
+	}
+	copySize := mem.Size
+	if size < copySize {
+		copySize = size
+	}
+	C.memcpy(cbuf, mem.Cbuf, C.size_t(copySize))
+	this.free(mem)
+	*this = *newBacking
+	mem.Cbuf = cbuf
+	mem.Size = size
+	mem.capacity = size
+	return nil
+}
+
+func (this *guardBacking) free(mem *Memory) {
+	if this.base == nil {
+		return
+	}
+	C.munmap(this.base, C.size_t(this.length))
+	this.base = nil
+}
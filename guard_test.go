@@ -0,0 +1,77 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"runtime/debug"
+	"testing"
+	"unsafe"
+)
+
+// faults reports whether writing a byte to address causes a memory fault.
+func faults(address unsafe.Pointer) (faulted bool) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			faulted = true
+		}
+	}()
+	*(*byte)(address) = 1
+	return false
+}
+
+func TestAllocGuarded(t *testing.T) {
+	mem, err := AllocGuarded(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if faults(mem.Cbuf) || faults(unsafe.Add(mem.Cbuf, 99)) {
+		t.Error("AllocGuarded() returned a block that can't be written")
+	}
+	if !faults(unsafe.Add(mem.Cbuf, 100)) {
+		t.Error("AllocGuarded() did not put a guard page after the block")
+	}
+	pageStart := uintptr(mem.Cbuf) &^ uintptr(os.Getpagesize()-1)
+	if !faults(unsafe.Add(mem.Cbuf, -int(uintptr(mem.Cbuf)-pageStart)-1)) {
+		t.Error("AllocGuarded() did not put a guard page before the block")
+	}
+}
+
+func TestGrowGuarded(t *testing.T) {
+	mem, _ := AllocGuarded(100)
+	defer mem.Close()
+	*(*byte)(mem.Cbuf) = 42
+	err := mem.Grow(5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *(*byte)(mem.Cbuf) != 42 {
+		t.Error("Grow() did not keep the contents")
+	}
+	if faults(unsafe.Add(mem.Cbuf, 4999)) {
+		t.Error("Grow() did not map enough pages")
+	}
+	if !faults(unsafe.Add(mem.Cbuf, 5000)) {
+		t.Error("Grow() did not move the guard page")
+	}
+}
+
+func TestShrinkGuarded(t *testing.T) {
+	mem, _ := AllocGuarded(64)
+	defer mem.Close()
+	mem.Seek(64, 0)
+	err := mem.Grow(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Read(make([]byte, 4)); err != io.EOF {
+		t.Error("Grow() didn't move the cursor back inside the block")
+	}
+	if _, err := mem.ReadUint32(binary.LittleEndian); err != io.EOF {
+		t.Error("ReadUint32() read past the end after shrinking")
+	}
+}
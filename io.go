@@ -0,0 +1,124 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"io"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// minRead is how much room ReadFrom makes in a growable block before each
+// read.
+const minRead = 512
+
+// maxEmptyReads is how many reads in a row ReadFrom lets return no data before
+// giving up with io.ErrNoProgress.
+const maxEmptyReads = 100
+
+// ReadFrom implements the io.ReaderFrom interface to read from reader straight
+// into the memory block at the cursor until reader returns io.EOF. A growable
+// block is extended as needed. If a fixed size block fills up while reader
+// still has data, ReadFrom returns io.ErrShortWrite, and the byte it read to
+// find that out is lost.
+func (this *Memory) ReadFrom(reader io.Reader) (int64, error) {
+	if err := this.writable(); err != nil {
+		return 0, err
+	}
+	var total int64
+	for {
+		var output []byte
+		if this.growable && this.cursor == this.Size {
+			err := this.Reserve(minRead)
+			if err != nil {
+				return total, err
+			}
+			output = unsafe.Slice((*byte)(this.Cbuf), this.capacity)[this.cursor:]
+		} else if this.cursor == this.Size {
+			return total, drained(reader)
+		} else {
+			output = this.Bytes()[this.cursor:]
+		}
+		bytesRead, err := reader.Read(output)
+		this.cursor += uint64(bytesRead)
+		if this.cursor > this.Size {
+			this.Size = this.cursor
+		}
+		total += int64(bytesRead)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// drained returns nil if reader has no more data, or io.ErrShortWrite if it
+// does.
+func drained(reader io.Reader) error {
+	var probe [1]byte
+	for attempt := 0; attempt < maxEmptyReads; attempt++ {
+		bytesRead, err := reader.Read(probe[:])
+		if bytesRead > 0 {
+			return io.ErrShortWrite
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return io.ErrNoProgress
+}
+
+// WriteTo implements the io.WriterTo interface to write the rest of the memory
+// block after the cursor to writer without an intermediate buffer.
+func (this *Memory) WriteTo(writer io.Writer) (int64, error) {
+	if err := this.readable(); err != nil {
+		return 0, err
+	}
+	input := this.Bytes()[this.cursor:]
+	bytesWritten, err := writer.Write(input)
+	this.cursor += uint64(bytesWritten)
+	if err == nil && bytesWritten < len(input) {
+		err = io.ErrShortWrite
+	}
+	return int64(bytesWritten), err
+}
+
+// WriteString implements the io.StringWriter interface to write a string to
+// the memory block without converting it to a byte slice first.
+func (this *Memory) WriteString(input string) (int, error) {
+	return this.Write(unsafe.Slice(unsafe.StringData(input), len(input)))
+}
+
+// ReadRune implements the io.RuneReader interface to decode one UTF-8 encoded
+// character from the memory block. Invalid encodings are returned as
+// utf8.RuneError with a size of 1.
+func (this *Memory) ReadRune() (rune, int, error) {
+	if err := this.readable(); err != nil {
+		return 0, 0, err
+	}
+	if this.cursor == this.Size {
+		return 0, 0, io.EOF
+	}
+	character, size := utf8.DecodeRune(this.Bytes()[this.cursor:])
+	this.cursor += uint64(size)
+	return character, size, nil
+}
+
+// UnreadRune implements the io.RuneScanner interface to move the cursor back
+// to the start of the character before it.
+func (this *Memory) UnreadRune() error {
+	if err := this.readable(); err != nil {
+		return err
+	}
+	if this.cursor == 0 {
+		return io.EOF
+	}
+	_, size := utf8.DecodeLastRune(this.Bytes()[:this.cursor])
+	this.cursor -= uint64(size)
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReaderConformance(t *testing.T) {
+	content := []byte("Hello, 世界! The quick brown fox jumps over the lazy dog.")
+	mem, _ := AllocFromSlice(content)
+	if err := iotest.TestReader(mem, content); err != nil {
+		t.Error(err)
+	}
+	mem.Seek(0, 0)
+	data, err := io.ReadAll(iotest.HalfReader(iotest.DataErrReader(mem)))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Error("Read() failed with a half reader")
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	content := initTestData()
+	mem, _ := AllocBuffer(0)
+	bytesRead, err := mem.ReadFrom(iotest.OneByteReader(bytes.NewReader(content)))
+	if err != nil || bytesRead != 256 {
+		t.Error("ReadFrom() did not read everything")
+	}
+	if !bytes.Equal(mem.Bytes(), content) {
+		t.Error("ReadFrom() read the wrong data")
+	}
+
+	fixed, _ := Alloc(100)
+	bytesRead, err = fixed.ReadFrom(bytes.NewReader(content))
+	if err != io.ErrShortWrite || bytesRead != 100 {
+		t.Error("ReadFrom() did not stop at the end of a fixed block")
+	}
+	if !bytes.Equal(fixed.Bytes(), content[:100]) {
+		t.Error("ReadFrom() read the wrong data")
+	}
+
+	exact, _ := Alloc(4)
+	bytesCopied, err := io.Copy(exact, bytes.NewReader(content[:4]))
+	if err != nil || bytesCopied != 4 {
+		t.Error("ReadFrom() failed when the data fit exactly")
+	}
+
+	mem, _ = AllocBuffer(0)
+	bytesRead, err = mem.ReadFrom(iotest.TimeoutReader(bytes.NewReader(content)))
+	if err != iotest.ErrTimeout || bytesRead != 256 {
+		t.Error("ReadFrom() did not return the reader's error")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	mem.Seek(56, 0)
+	var buffer bytes.Buffer
+	bytesWritten, err := mem.WriteTo(&buffer)
+	if err != nil || bytesWritten != 200 {
+		t.Error("WriteTo() did not write the rest of the block")
+	}
+	if !bytes.Equal(buffer.Bytes(), initTestData()[56:]) {
+		t.Error("WriteTo() wrote the wrong data")
+	}
+	if mem.cursor != 256 {
+		t.Error("WriteTo() did not move the cursor")
+	}
+	mem.Seek(0, 0)
+	_, err = io.Copy(iotest.TruncateWriter(io.Discard, 10), mem)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	mem, _ := AllocBuffer(0)
+	io.WriteString(mem, "Hello, ")
+	bytesWritten, err := mem.WriteString("world")
+	if err != nil || bytesWritten != 5 {
+		t.Error("WriteString() did not write the string")
+	}
+	if string(mem.Bytes()) != "Hello, world" {
+		t.Error("WriteString() wrote the wrong data")
+	}
+}
+
+func TestReadRune(t *testing.T) {
+	content := "a世\xffb"
+	mem, _ := AllocFromSlice([]byte(content))
+	expected := []struct {
+		character rune
+		size      int
+	}{{'a', 1}, {'世', 3}, {'�', 1}, {'b', 1}}
+	for _, want := range expected {
+		character, size, err := mem.ReadRune()
+		if err != nil || character != want.character || size != want.size {
+			t.Errorf("ReadRune() = %q, %d, %v, want %q, %d", character, size, err, want.character, want.size)
+		}
+	}
+	if _, _, err := mem.ReadRune(); err != io.EOF {
+		t.Error("ReadRune() failed to return EOF")
+	}
+	mem.Seek(4, 0)
+	if err := mem.UnreadRune(); err != nil {
+		t.Error(err)
+	}
+	if mem.cursor != 1 {
+		t.Error("UnreadRune() did not move back a whole character")
+	}
+	mem.Seek(0, 0)
+	if mem.UnreadRune() != io.EOF {
+		t.Error("UnreadRune() failed to return EOF")
+	}
+}
+
+func TestRegexpOverMemory(t *testing.T) {
+	mem, _ := AllocFromSlice([]byte("id=1234; name=gopher"))
+	location := regexp.MustCompile(`name=(\w+)`).FindReaderSubmatchIndex(mem)
+	if location == nil || location[2] != 14 || location[3] != 20 {
+		t.Error("regexp could not scan the block")
+	}
+	var builder strings.Builder
+	mem.Seek(0, 0)
+	io.Copy(&builder, mem)
+	if builder.String() != "id=1234; name=gopher" {
+		t.Error("io.Copy() out of the block returned the wrong data")
+	}
+}
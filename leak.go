@@ -0,0 +1,74 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync/atomic"
+)
+
+// maxLeakFrames is the number of stack frames recorded for each Memory.
+const maxLeakFrames = 32
+
+// Leak describes a Memory that was garbage collected without being closed.
+type Leak struct {
+	// Size is the size of the block when it was finalized.
+	Size uint64
+	// Stack is the stack trace of the code that created the Memory,
+	// starting with the constructor.
+	Stack string
+}
+
+var leakHandler atomic.Pointer[func(Leak)]
+
+// SetLeakHandler turns on leak reporting, for programs that close every
+// Memory explicitly and want to find the places that forget to. From then on
+// the stack is recorded whenever a Memory that owns its block is created, and
+// if one reaches its finalizer without Close() having been called, handler is
+// called with that stack before the block is freed. handler runs on the
+// finalizer goroutine, so it shouldn't block. LogLeak can be used to write the
+// reports to the standard logger. A nil handler turns reporting off again.
+func SetLeakHandler(handler func(Leak)) {
+	if handler == nil {
+		leakHandler.Store(nil)
+		return
+	}
+	leakHandler.Store(&handler)
+}
+
+// LogLeak writes a leak report to the standard logger.
+func LogLeak(leak Leak) {
+	log.Printf("cmemory: a Memory of %d bytes was never closed. It was created at:\n%s", leak.Size, leak.Stack)
+}
+
+// recordCreation saves the stack of the constructor that is creating the
+// Memory, if leak reporting is on.
+func (this *Memory) recordCreation() {
+	if leakHandler.Load() == nil {
+		return
+	}
+	stack := make([]uintptr, maxLeakFrames)
+	// Skip runtime.Callers, this function, and own().
+	this.creation = stack[:runtime.Callers(3, stack)]
+}
+
+// reportLeak calls the leak handler for a Memory that is being finalized.
+func reportLeak(deadMemory *Memory) {
+	handler := leakHandler.Load()
+	if handler == nil || deadMemory.creation == nil {
+		return
+	}
+	var stack string
+	frames := runtime.CallersFrames(deadMemory.creation)
+	for {
+		frame, more := frames.Next()
+		stack += frame.Function + "\n"
+		stack += fmt.Sprintf("\t%s:%d (0x%x)\n", frame.File, frame.Line, frame.PC)
+		if !more {
+			break
+		}
+	}
+	(*handler)(Leak{deadMemory.Size, stack})
+}
@@ -0,0 +1,66 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go:noinline
+func leakMemory() {
+	Alloc(123)
+}
+
+func TestLeakHandler(t *testing.T) {
+	leaks := make(chan Leak, 16)
+	SetLeakHandler(func(leak Leak) {
+		if leak.Size == 123 {
+			leaks <- leak
+		}
+	})
+	defer SetLeakHandler(nil)
+	closed, _ := Alloc(123)
+	closed.Close()
+	leakMemory()
+	runtime.GC()
+	select {
+	case leak := <-leaks:
+		if !strings.HasPrefix(leak.Stack, "github.com/emilymaier/cmemory.Alloc\n") || !strings.Contains(leak.Stack, "cmemory.leakMemory\n") {
+			t.Errorf("Leak has the wrong stack:\n%s", leak.Stack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Leak wasn't reported")
+	}
+	runtime.GC()
+	select {
+	case <-leaks:
+		t.Error("A closed Memory was reported as a leak")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLeakHandlerOff(t *testing.T) {
+	mem, _ := Alloc(8)
+	defer mem.Close()
+	if mem.creation != nil {
+		t.Error("Stack recorded without a leak handler")
+	}
+}
+
+func TestFinalizeTwice(t *testing.T) {
+	mem, _ := Alloc(64)
+	finalizeMemory(mem)
+	finalizeMemory(mem)
+	if mem.Close() != nil || mem.Cbuf != nil {
+		t.Error("Close() after the finalizer didn't do nothing")
+	}
+	if _, err := mem.Read(make([]byte, 1)); err != ErrClosed {
+		t.Error("Finalized Memory wasn't marked closed")
+	}
+	if mem.accounted != 0 {
+		t.Error("Finalized block is still counted")
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var ErrOwnerDead = errors.New("Previous owner of the lock died while holding it")
+var ErrNotLocked = errors.New("Lock is not held")
+
+// The locks here live entirely inside a Memory, so when it is shared memory
+// they synchronize every process that maps it. All zero bytes is an unlocked
+// lock, which is what a new shared memory object holds.
+//
+// A locked Mutex holds the ID of the process that locked it. If that process
+// dies without unlocking, the next Lock notices and takes the lock over, but
+// returns ErrOwnerDead to warn that whatever the lock protects may be half
+// updated. Like sync.Mutex, a lock isn't tied to a goroutine, so any goroutine
+// in any process can unlock it. Process IDs are looked up in the caller's PID
+// namespace, so all processes using a lock should share one.
+
+const (
+	// MutexSize is the number of bytes a Mutex takes up.
+	MutexSize = 4
+	// RWMutexSize is the number of bytes an RWMutex takes up.
+	RWMutexSize = 8 + rwReaderSlots*8
+	// CondSize is the number of bytes a Cond takes up.
+	CondSize = 4
+)
+
+const (
+	futexWait = 0
+	futexWake = 1
+	// lockWaiters is set in a locked Mutex when processes may be sleeping
+	// on it.
+	lockWaiters = 1 << 31
+	// ownerCheckInterval is how often waiters check whether the holder of
+	// a lock is still alive.
+	ownerCheckInterval = 100 * time.Millisecond
+	// rwReaderSlots is the number of processes that can hold an RWMutex
+	// for reading at once.
+	rwReaderSlots = 16
+)
+
+// wait sleeps until the word at address is woken, if it still holds value. A
+// timeout of 0 waits forever. Wakeups can be spurious.
+func wait(address *uint32, value uint32, timeout time.Duration) {
+	var timespec *syscall.Timespec
+	if timeout != 0 {
+		converted := syscall.NsecToTimespec(int64(timeout))
+		timespec = &converted
+	}
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(address)), futexWait, uintptr(value), uintptr(unsafe.Pointer(timespec)), 0, 0)
+}
+
+// wake wakes up to count waiters on the word at address.
+func wake(address *uint32, count int) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(address)), futexWake, uintptr(count), 0, 0, 0)
+}
+
+// processAlive returns whether a process exists.
+func processAlive(pid uint32) bool {
+	return syscall.Kill(int(pid), 0) != syscall.ESRCH
+}
+
+// Mutex is a mutual exclusion lock stored in MutexSize bytes of a Memory.
+type Mutex struct {
+	mem    *Memory
+	offset uint64
+}
+
+// MutexAt returns the Mutex at offset in mem, which has to be 4-byte aligned.
+func MutexAt(mem *Memory, offset uint64) (*Mutex, error) {
+	_, err := mem.atomicWritableAddress(offset, MutexSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Mutex{mem, offset}, nil
+}
+
+func (this *Mutex) word() (*uint32, error) {
+	address, err := this.mem.atomicWritableAddress(this.offset, MutexSize)
+	return (*uint32)(address), err
+}
+
+// lock acquires the mutex, or gives up if it is held and block is false.
+func (this *Mutex) lock(block bool) (bool, error) {
+	word, err := this.word()
+	if err != nil {
+		return false, err
+	}
+	self := uint32(os.Getpid())
+	if atomic.CompareAndSwapUint32(word, 0, self) {
+		return true, nil
+	}
+	for {
+		state := atomic.LoadUint32(word)
+		switch {
+		case state == 0:
+			// Other processes may be asleep too, so make sure they'll
+			// be woken.
+			if atomic.CompareAndSwapUint32(word, 0, self|lockWaiters) {
+				return true, nil
+			}
+			continue
+		case !processAlive(state &^ lockWaiters):
+			if atomic.CompareAndSwapUint32(word, state, self|lockWaiters) {
+				return true, ErrOwnerDead
+			}
+			continue
+		case !block:
+			return false, nil
+		case state&lockWaiters == 0 && !atomic.CompareAndSwapUint32(word, state, state|lockWaiters):
+			continue
+		}
+		wait(word, state|lockWaiters, ownerCheckInterval)
+	}
+}
+
+// Lock locks the mutex, waiting until it is available. If the process holding
+// it died, Lock takes it over and returns ErrOwnerDead.
+func (this *Mutex) Lock() error {
+	_, err := this.lock(true)
+	return err
+}
+
+// TryLock locks the mutex if it is available and returns whether it did.
+func (this *Mutex) TryLock() (bool, error) {
+	return this.lock(false)
+}
+
+// Unlock unlocks the mutex, or returns ErrNotLocked if it isn't locked.
+func (this *Mutex) Unlock() error {
+	word, err := this.word()
+	if err != nil {
+		return err
+	}
+	state := atomic.SwapUint32(word, 0)
+	if state == 0 {
+		return ErrNotLocked
+	}
+	if state&lockWaiters != 0 {
+		wake(word, 1)
+	}
+	return nil
+}
+
+// RWMutex is a reader/writer lock stored in RWMutexSize bytes of a Memory. It
+// starts with a Mutex that writers hold, followed by a wakeup counter and a
+// slot for each process holding a read lock, with its process ID in the high
+// 32 bits and its number of read locks in the low 32 bits. The slots of dead
+// processes are cleared, so they can't block writers forever. Up to 16
+// processes can hold read locks at once; more wait for a free slot.
+type RWMutex struct {
+	writer *Mutex
+	mem    *Memory
+	offset uint64
+}
+
+// RWMutexAt returns the RWMutex at offset in mem, which has to be 8-byte
+// aligned.
+func RWMutexAt(mem *Memory, offset uint64) (*RWMutex, error) {
+	_, err := mem.atomicWritableAddress(offset, 8)
+	if err != nil {
+		return nil, err
+	}
+	_, err = mem.atomicWritableAddress(offset+RWMutexSize-8, 8)
+	if err != nil {
+		return nil, err
+	}
+	return &RWMutex{&Mutex{mem, offset}, mem, offset}, nil
+}
+
+func (this *RWMutex) generation() (*uint32, error) {
+	address, err := this.mem.atomicWritableAddress(this.offset+4, 4)
+	return (*uint32)(address), err
+}
+
+func (this *RWMutex) slot(index uint64) (*uint64, error) {
+	address, err := this.mem.atomicWritableAddress(this.offset+8+index*8, 8)
+	return (*uint64)(address), err
+}
+
+// claimSlot adds a read lock for this process to its slot, or to a free one.
+// It is only called with the writer mutex held, so it only races with
+// RUnlock.
+func (this *RWMutex) claimSlot(self uint64) (bool, error) {
+	for index := uint64(0); index < rwReaderSlots; index++ {
+		slot, err := this.slot(index)
+		if err != nil {
+			return false, err
+		}
+		for {
+			state := atomic.LoadUint64(slot)
+			if state>>32 != self {
+				break
+			}
+			if atomic.CompareAndSwapUint64(slot, state, state+1) {
+				return true, nil
+			}
+		}
+	}
+	for index := uint64(0); index < rwReaderSlots; index++ {
+		slot, err := this.slot(index)
+		if err != nil {
+			return false, err
+		}
+		state := atomic.LoadUint64(slot)
+		if (state == 0 || !processAlive(uint32(state>>32))) && atomic.CompareAndSwapUint64(slot, state, self<<32|1) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RLock locks the mutex for reading, waiting while a writer holds it. It
+// returns ErrOwnerDead if a writer died while holding it.
+func (this *RWMutex) RLock() error {
+	self := uint64(os.Getpid())
+	var ownerErr error
+	for attempt := 0; ; attempt++ {
+		err := this.writer.Lock()
+		if err == ErrOwnerDead {
+			ownerErr = err
+		} else if err != nil {
+			return err
+		}
+		claimed, err := this.claimSlot(self)
+		this.writer.Unlock()
+		if err != nil {
+			return err
+		}
+		if claimed {
+			return ownerErr
+		}
+		backoff(attempt)
+	}
+}
+
+// RUnlock undoes one RLock by this process.
+func (this *RWMutex) RUnlock() error {
+	self := uint64(os.Getpid())
+	for index := uint64(0); index < rwReaderSlots; index++ {
+		slot, err := this.slot(index)
+		if err != nil {
+			return err
+		}
+		for {
+			state := atomic.LoadUint64(slot)
+			if state>>32 != self {
+				break
+			}
+			next := state - 1
+			if uint32(next) == 0 {
+				next = 0
+			}
+			if atomic.CompareAndSwapUint64(slot, state, next) {
+				generation, _ := this.generation()
+				atomic.AddUint32(generation, 1)
+				wake(generation, math.MaxInt32)
+				return nil
+			}
+		}
+	}
+	return ErrNotLocked
+}
+
+// readersGone returns whether no live process holds a read lock.
+func (this *RWMutex) readersGone() (bool, error) {
+	gone := true
+	for index := uint64(0); index < rwReaderSlots; index++ {
+		slot, err := this.slot(index)
+		if err != nil {
+			return false, err
+		}
+		state := atomic.LoadUint64(slot)
+		if state == 0 {
+			continue
+		}
+		if !processAlive(uint32(state >> 32)) {
+			atomic.CompareAndSwapUint64(slot, state, 0)
+			continue
+		}
+		gone = false
+	}
+	return gone, nil
+}
+
+// Lock locks the mutex for writing, waiting for other writers and for readers
+// to finish. It returns ErrOwnerDead if a writer died while holding it.
+func (this *RWMutex) Lock() error {
+	ownerErr := this.writer.Lock()
+	if ownerErr != nil && ownerErr != ErrOwnerDead {
+		return ownerErr
+	}
+	generation, err := this.generation()
+	if err != nil {
+		return err
+	}
+	for {
+		value := atomic.LoadUint32(generation)
+		gone, err := this.readersGone()
+		if err != nil {
+			this.writer.Unlock()
+			return err
+		}
+		if gone {
+			return ownerErr
+		}
+		wait(generation, value, ownerCheckInterval)
+	}
+}
+
+// Unlock unlocks the mutex for writing.
+func (this *RWMutex) Unlock() error {
+	return this.writer.Unlock()
+}
+
+// Cond is a condition variable stored in CondSize bytes of a Memory, for use
+// with a Mutex. As with sync.Cond, Wait can return without a Signal, so it
+// should be called in a loop that checks the condition.
+type Cond struct {
+	mem    *Memory
+	offset uint64
+}
+
+// CondAt returns the Cond at offset in mem, which has to be 4-byte aligned.
+func CondAt(mem *Memory, offset uint64) (*Cond, error) {
+	_, err := mem.atomicWritableAddress(offset, CondSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Cond{mem, offset}, nil
+}
+
+func (this *Cond) word() (*uint32, error) {
+	address, err := this.mem.atomicWritableAddress(this.offset, CondSize)
+	return (*uint32)(address), err
+}
+
+// Wait unlocks mutex, waits to be signaled, and locks mutex again before
+// returning. mutex has to be locked by the caller.
+func (this *Cond) Wait(mutex *Mutex) error {
+	word, err := this.word()
+	if err != nil {
+		return err
+	}
+	sequence := atomic.LoadUint32(word)
+	err = mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	wait(word, sequence, 0)
+	return mutex.Lock()
+}
+
+// Signal wakes one waiter, if there are any.
+func (this *Cond) Signal() error {
+	return this.notify(1)
+}
+
+// Broadcast wakes all waiters.
+func (this *Cond) Broadcast() error {
+	return this.notify(math.MaxInt32)
+}
+
+func (this *Cond) notify(count int) error {
+	word, err := this.word()
+	if err != nil {
+		return err
+	}
+	atomic.AddUint32(word, 1)
+	wake(word, count)
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// runLockChild runs TestLockChild in a new process with mem's descriptor as
+// fd 3, waits for it to exit, and returns its process ID.
+func runLockChild(t *testing.T, mem *Memory, action string) uint32 {
+	fd, _ := mem.Fd()
+	dup, _ := syscall.Dup(fd)
+	file := os.NewFile(uintptr(dup), "shared")
+	defer file.Close()
+	command := exec.Command(os.Args[0], "-test.run=^TestLockChild$")
+	command.Env = append(os.Environ(), "CMEMORY_LOCK_CHILD="+action)
+	command.ExtraFiles = []*os.File{file}
+	output, err := command.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Child process failed: %v\n%s", err, output)
+	}
+	return uint32(command.Process.Pid)
+}
+
+// TestLockChild takes a lock in shared memory and exits without releasing it.
+// It only runs as a child of the other tests.
+func TestLockChild(t *testing.T) {
+	action := os.Getenv("CMEMORY_LOCK_CHILD")
+	if action == "" {
+		t.Skip("Only runs as a child process")
+	}
+	mem, err := MapFd(3, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch action {
+	case "mutex":
+		mutex, _ := MutexAt(mem, 0)
+		err = mutex.Lock()
+	case "rlock":
+		rwMutex, _ := RWMutexAt(mem, 0)
+		err = rwMutex.RLock()
+	case "wlock":
+		rwMutex, _ := RWMutexAt(mem, 0)
+		err = rwMutex.Lock()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+func TestMutex(t *testing.T) {
+	const goroutines, count = 8, 1000
+	mem, _ := AllocAligned(16, 8)
+	defer mem.Close()
+	clear(mem.Bytes())
+	mutex, err := MutexAt(mem, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := (*uint64)(unsafe.Add(mem.Cbuf, 8))
+	var group sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for j := 0; j < count; j++ {
+				mutex.Lock()
+				*counter += 1
+				mutex.Unlock()
+			}
+		}()
+	}
+	group.Wait()
+	if *counter != goroutines*count {
+		t.Errorf("Counter is %d, updates were lost", *counter)
+	}
+
+	mutex.Lock()
+	if locked, _ := mutex.TryLock(); locked {
+		t.Error("TryLock() locked a held mutex")
+	}
+	mutex.Unlock()
+	if err := mutex.Unlock(); err != ErrNotLocked {
+		t.Error("Unlock() of an unlocked mutex didn't return ErrNotLocked")
+	}
+	if _, err := MutexAt(mem, 2); err != ErrMisaligned {
+		t.Error("MutexAt() accepted a misaligned offset")
+	}
+}
+
+func TestMutexOwnerDead(t *testing.T) {
+	mem, err := AllocShared(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	runLockChild(t, mem, "mutex")
+	mutex, _ := MutexAt(mem, 0)
+	if err := mutex.Lock(); err != ErrOwnerDead {
+		t.Fatalf("Lock() of a dead process's mutex returned %v", err)
+	}
+	if locked, _ := mutex.TryLock(); locked {
+		t.Error("Lock() didn't take over the mutex")
+	}
+	if err := mutex.Unlock(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRWMutex(t *testing.T) {
+	mem, _ := AllocAligned(RWMutexSize, 8)
+	defer mem.Close()
+	clear(mem.Bytes())
+	rwMutex, err := RWMutexAt(mem, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rwMutex.RLock()
+	rwMutex.RLock()
+	var writing atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		rwMutex.Lock()
+		writing.Store(true)
+		rwMutex.Unlock()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if writing.Load() {
+		t.Error("Lock() didn't wait for readers")
+	}
+	rwMutex.RUnlock()
+	time.Sleep(10 * time.Millisecond)
+	if writing.Load() {
+		t.Error("Lock() didn't wait for the last reader")
+	}
+	rwMutex.RUnlock()
+	<-done
+	if err := rwMutex.RUnlock(); err != ErrNotLocked {
+		t.Error("RUnlock() without a read lock didn't return ErrNotLocked")
+	}
+}
+
+func TestRWMutexOwnerDead(t *testing.T) {
+	mem, err := AllocShared(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	rwMutex, _ := RWMutexAt(mem, 0)
+	runLockChild(t, mem, "rlock")
+	if err := rwMutex.Lock(); err != nil {
+		t.Fatalf("Lock() with a dead reader returned %v", err)
+	}
+	rwMutex.Unlock()
+	runLockChild(t, mem, "wlock")
+	if err := rwMutex.RLock(); err != ErrOwnerDead {
+		t.Fatalf("RLock() with a dead writer returned %v", err)
+	}
+	rwMutex.RUnlock()
+}
+
+func TestCond(t *testing.T) {
+	mem, _ := AllocAligned(16, 8)
+	defer mem.Close()
+	clear(mem.Bytes())
+	mutex, _ := MutexAt(mem, 0)
+	cond, err := CondAt(mem, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := (*uint32)(unsafe.Add(mem.Cbuf, 8))
+	const waiters = 4
+	var group sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			mutex.Lock()
+			for *ready == 0 {
+				cond.Wait(mutex)
+			}
+			mutex.Unlock()
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	mutex.Lock()
+	*ready = 1
+	cond.Broadcast()
+	mutex.Unlock()
+	group.Wait()
+}
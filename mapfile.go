@@ -0,0 +1,144 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+/*
+#include <sys/mman.h>
+#include <sys/types.h>
+
+void* map_pages(size_t length, int prot, int flags, int fd, off_t offset);
+*/
+import "C"
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+var ErrReadOnly = errors.New("Memory block is read-only")
+var ErrNotMapped = errors.New("Memory block is not a file mapping")
+
+// fileBacking is a shared mapping of an open file.
+type fileBacking struct {
+	file *os.File
+	prot C.int
+}
+
+// MapFile creates a new Memory struct that maps the whole file at path. flag
+// is os.O_RDONLY or os.O_RDWR. Writes to a read-write mapping go straight to
+// the file; Write and WriteAt on a read-only mapping return ErrReadOnly. The
+// file stays open until the Memory is closed.
+func MapFile(path string, flag int) (*Memory, error) {
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	newMemory, err := mapOpenFile(file, flag&(os.O_WRONLY|os.O_RDWR) == 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newMemory, nil
+}
+
+// mapOpenFile creates a new Memory struct that maps all of file and takes
+// ownership of it.
+func mapOpenFile(file *os.File, readOnly bool) (*Memory, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	backing := &fileBacking{file, C.PROT_READ}
+	if !readOnly {
+		backing.prot |= C.PROT_WRITE
+	}
+	cbuf, err := backing.mapFile(uint64(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	newMemory := new(Memory)
+	newMemory.Cbuf = cbuf
+	runtime.SetFinalizer(newMemory, finalizeMemory)
+	newMemory.Size = uint64(info.Size())
+	newMemory.capacity = newMemory.Size
+	newMemory.readOnly = readOnly
+	newMemory.backing = backing
+	return newMemory, nil
+}
+
+// mapFile maps the first size bytes of the file. mmap() can't map an empty
+// range, so an empty file gets a nil pointer.
+func (this *fileBacking) mapFile(size uint64) (unsafe.Pointer, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	cbuf, err := C.map_pages(C.size_t(size), this.prot, C.MAP_SHARED, C.int(this.file.Fd()), 0)
+	if cbuf == nil {
+		return nil, err
+	}
+	return cbuf, nil
+}
+
+func (this *fileBacking) resize(mem *Memory, size uint64) error {
+	if mem.readOnly {
+		return ErrReadOnly
+	}
+	err := this.file.Truncate(int64(size))
+	if err != nil {
+		return err
+	}
+	cbuf, err := this.mapFile(size)
+	if err != nil {
+		return err
+	}
+	if mem.Cbuf != nil {
+		C.munmap(mem.Cbuf, C.size_t(mem.Size))
+	}
+	mem.Cbuf = cbuf
+	mem.Size = size
+	mem.capacity = size
+	return nil
+}
+
+func (this *fileBacking) free(mem *Memory) {
+	if this.file == nil {
+		return
+	}
+	if mem.Cbuf != nil {
+		C.munmap(mem.Cbuf, C.size_t(mem.Size))
+	}
+	this.file.Close()
+	this.file = nil
+}
+
+// Sync flushes changes made to a file mapping back to the file with msync().
+// It returns ErrNotMapped for blocks that aren't file mappings.
+func (this *Memory) Sync() error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	if _, ok := this.backing.(*fileBacking); !ok {
+		return ErrNotMapped
+	}
+	if this.Cbuf == nil {
+		return nil
+	}
+	_, err := C.msync(this.Cbuf, C.size_t(this.Size), C.MS_SYNC)
+	return err
+}
+
+// Fd returns the file descriptor behind a file or shared memory mapping, for
+// example to pass it to another process. It stays owned by the Memory. Fd
+// returns ErrNotMapped for blocks that aren't mappings.
+func (this *Memory) Fd() (int, error) {
+	if this.isClosed() {
+		return -1, ErrClosed
+	}
+	backing, ok := this.backing.(*fileBacking)
+	if !ok || backing.file == nil {
+		return -1, ErrNotMapped
+	}
+	return int(backing.file.Fd()), nil
+}
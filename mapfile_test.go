@@ -0,0 +1,75 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestMapFileReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	os.WriteFile(path, initTestData(), 0600)
+	mem, err := MapFile(path, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if mem.Size != 256 {
+		t.Error("MapFile() set the wrong size")
+	}
+	if !bytes.Equal(unsafe.Slice((*byte)(mem.Cbuf), mem.Size), initTestData()) {
+		t.Error("MapFile() did not map the file contents")
+	}
+	if _, err := mem.Write([]byte{1}); err != ErrReadOnly {
+		t.Error("Write() did not fail on a read-only mapping")
+	}
+	if _, err := mem.WriteAt([]byte{1}, 0); err != ErrReadOnly {
+		t.Error("WriteAt() did not fail on a read-only mapping")
+	}
+	if err := mem.WriteByte(1); err != ErrReadOnly {
+		t.Error("WriteByte() did not fail on a read-only mapping")
+	}
+	if err := mem.Grow(512); err != ErrReadOnly {
+		t.Error("Grow() did not fail on a read-only mapping")
+	}
+}
+
+func TestMapFileReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	os.WriteFile(path, initTestData(), 0600)
+	mem, err := MapFile(path, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*(*byte)(mem.Cbuf) = 0xff
+	if err := mem.Sync(); err != nil {
+		t.Error(err)
+	}
+	data, _ := os.ReadFile(path)
+	if data[0] != 0xff {
+		t.Error("Sync() did not write changes to the file")
+	}
+	if err := mem.Grow(8192); err != nil {
+		t.Fatal(err)
+	}
+	*(*byte)(unsafe.Add(mem.Cbuf, 8191)) = 0xee
+	if *(*byte)(unsafe.Add(mem.Cbuf, 1)) != 1 {
+		t.Error("Grow() did not keep the contents")
+	}
+	mem.Close()
+	data, _ = os.ReadFile(path)
+	if len(data) != 8192 || data[8191] != 0xee {
+		t.Error("Grow() did not extend the file")
+	}
+}
+
+func TestSyncNotMapped(t *testing.T) {
+	mem, _ := Alloc(16)
+	if mem.Sync() != ErrNotMapped {
+		t.Error("Sync() did not reject a heap block")
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright © 2014 Emily Maier
+
+#include <stddef.h>
+#include <sys/mman.h>
+#include <sys/types.h>
+
+// Calls mmap(), returning NULL instead of MAP_FAILED on failure so that the Go
+// side doesn't have to construct MAP_FAILED itself. errno is left as mmap() set
+// it.
+void* map_pages(size_t length, int prot, int flags, int fd, off_t offset)
+{
+	void* ptr = mmap(NULL, length, prot, flags, fd, offset);
+	if(ptr == MAP_FAILED)
+	{
+		return NULL;
+	}
+	return ptr;
+}
@@ -0,0 +1,119 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"errors"
+	"math/bits"
+	"sync"
+)
+
+var ErrTooLarge = errors.New("Size is too large for a pool size class")
+
+// Pool keeps freed C blocks in power-of-two size classes and hands them out
+// again instead of calling malloc(). It is safe for concurrent use.
+type Pool struct {
+	mutex    sync.Mutex
+	classes  [64][]*Memory
+	maxBytes uint64
+	retained uint64
+	hits     uint64
+	misses   uint64
+}
+
+// PoolStats contains the counters of a Pool.
+type PoolStats struct {
+	Hits          uint64
+	Misses        uint64
+	RetainedBytes uint64
+}
+
+// NewPool creates a Pool that holds on to at most maxBytes bytes of freed
+// blocks.
+func NewPool(maxBytes uint64) *Pool {
+	return &Pool{maxBytes: maxBytes}
+}
+
+// sizeClass returns the index of the smallest power of two that fits size.
+func sizeClass(size uint64) int {
+	if size <= 1 {
+		return 0
+	}
+	return bits.Len64(size - 1)
+}
+
+// Alloc returns a Memory of the given size, reusing a freed block from the
+// same size class if one is available. Sizes over 1<<63 have no size class and
+// return ErrTooLarge.
+func (this *Pool) Alloc(size uint64) (*Memory, error) {
+	class := sizeClass(size)
+	if class >= len(this.classes) {
+		return nil, ErrTooLarge
+	}
+	this.mutex.Lock()
+	if free := this.classes[class]; len(free) > 0 {
+		mem := free[len(free)-1]
+		this.classes[class] = free[:len(free)-1]
+		this.retained -= mem.capacity
+		this.hits += 1
+		this.mutex.Unlock()
+		mem.Size = size
+		mem.cursor = 0
+		mem.growable = false
+		return mem, nil
+	}
+	this.misses += 1
+	this.mutex.Unlock()
+	mem, err := Alloc(uint64(1) << uint(class))
+	if err != nil {
+		return mem, err
+	}
+	mem.Size = size
+	return mem, nil
+}
+
+// Free returns a block to the pool. Blocks that don't fit in a size class, or
+// that would push the pool over its limit, are freed instead, and retained
+// blocks are closed so that the last Release() frees them. Closed blocks are
+// ignored. The Memory must not be used after calling Free.
+func (this *Pool) Free(mem *Memory) {
+	if mem.closed {
+		return
+	}
+	if mem.borrowed || mem.backing != nil || mem.inUse() || mem.capacity == 0 || mem.capacity&(mem.capacity-1) != 0 {
+		mem.Close()
+		return
+	}
+	this.mutex.Lock()
+	if this.retained+mem.capacity > this.maxBytes {
+		this.mutex.Unlock()
+		mem.Close()
+		return
+	}
+	class := sizeClass(mem.capacity)
+	this.classes[class] = append(this.classes[class], mem)
+	this.retained += mem.capacity
+	this.mutex.Unlock()
+}
+
+// Stats returns the current hit, miss, and retained byte counters.
+func (this *Pool) Stats() PoolStats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return PoolStats{this.hits, this.misses, this.retained}
+}
+
+// Close implements the io.Closer interface to free every block held by the
+// pool. The pool can still be used afterwards.
+func (this *Pool) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for class, free := range this.classes {
+		for _, mem := range free {
+			mem.Close()
+		}
+		this.classes[class] = nil
+	}
+	this.retained = 0
+	return nil
+}
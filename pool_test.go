@@ -0,0 +1,82 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import "testing"
+
+func TestPoolReuse(t *testing.T) {
+	pool := NewPool(4096)
+	defer pool.Close()
+	mem, err := pool.Alloc(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Size != 100 || mem.capacity != 128 {
+		t.Error("Alloc() did not round up to the size class")
+	}
+	block := mem.Cbuf
+	pool.Free(mem)
+	stats := pool.Stats()
+	if stats.RetainedBytes != 128 {
+		t.Error("Free() did not retain the block")
+	}
+	mem, _ = pool.Alloc(120)
+	if mem.Cbuf != block {
+		t.Error("Alloc() did not reuse a block from the same size class")
+	}
+	if mem.Size != 120 {
+		t.Error("Alloc() did not set the size of a reused block")
+	}
+	stats = pool.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.RetainedBytes != 0 {
+		t.Error("Stats() returned the wrong counters")
+	}
+	other, _ := pool.Alloc(200)
+	if other.Cbuf == block {
+		t.Error("Alloc() handed out a block that was in use")
+	}
+	pool.Free(other)
+	pool.Free(mem)
+	if _, err := pool.Alloc(1<<63 + 1); err != ErrTooLarge {
+		t.Error("Alloc() accepted a size with no size class")
+	}
+}
+
+func TestPoolLimit(t *testing.T) {
+	pool := NewPool(256)
+	defer pool.Close()
+	first, _ := pool.Alloc(256)
+	second, _ := pool.Alloc(256)
+	pool.Free(first)
+	pool.Free(second)
+	if pool.Stats().RetainedBytes != 256 {
+		t.Error("Free() let the pool grow past its limit")
+	}
+	odd, _ := Alloc(100)
+	pool.Free(odd)
+	if pool.Stats().RetainedBytes != 256 {
+		t.Error("Free() kept a block that doesn't fit a size class")
+	}
+	pool.Close()
+	if pool.Stats().RetainedBytes != 0 {
+		t.Error("Close() did not release the retained blocks")
+	}
+}
+
+func TestPoolRetained(t *testing.T) {
+	pool := NewPool(4096)
+	defer pool.Close()
+	mem, _ := pool.Alloc(64)
+	pointer, _ := mem.Retain()
+	pool.Free(mem)
+	if pool.Stats().RetainedBytes != 0 {
+		t.Error("Free() pooled a retained block")
+	}
+	if other, _ := pool.Alloc(64); other.Cbuf == pointer {
+		t.Error("Alloc() handed out a block that C still holds")
+	}
+	mem.Release()
+	if mem.Cbuf != nil {
+		t.Error("Last Release() didn't free the block")
+	}
+}
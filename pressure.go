@@ -0,0 +1,116 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// The garbage collector only sees the few bytes of each Memory struct, not the
+// C memory behind it, so a program can hold on to gigabytes of unreachable
+// blocks that are waiting for their finalizers while the Go heap looks too
+// small to collect. To make up for that, the bytes owned by Memory objects on
+// the C heap and in anonymous mappings are counted, and the collector is run
+// once they grow far enough. File mappings and shared memory aren't counted,
+// since the kernel can write their pages back to the file instead of keeping
+// them in RAM.
+
+// DefaultGCThreshold is the GC threshold that is used until SetGCThreshold is
+// called.
+const DefaultGCThreshold = 64 << 20
+
+var liveBytes atomic.Uint64
+var gcThreshold atomic.Uint64
+var memoryLimit atomic.Uint64
+
+// gcBaseline is the number of live bytes after the last collection, or the
+// lowest number since then.
+var gcBaseline atomic.Uint64
+var collecting atomic.Bool
+
+func init() {
+	gcThreshold.Store(DefaultGCThreshold)
+}
+
+// LiveBytes returns the number of bytes currently owned by Memory objects,
+// including ones that are unreachable but haven't been finalized yet.
+func LiveBytes() uint64 {
+	return liveBytes.Load()
+}
+
+// SetGCThreshold makes a garbage collection start in the background whenever
+// the bytes owned by Memory objects have grown by threshold since the last
+// one, so that blocks which are only freed by their finalizers get freed. A
+// threshold of 0 turns this off.
+func SetGCThreshold(threshold uint64) {
+	gcThreshold.Store(threshold)
+}
+
+// SetMemoryLimit applies back-pressure once the bytes owned by Memory objects
+// go over limit: the goroutine whose allocation crossed the limit runs the
+// garbage collector itself and waits briefly for finalizers to free blocks
+// before the allocation returns. If the blocks that are still reachable keep
+// the count over the limit, later allocations don't collect again until it has
+// dropped below the limit and crossed it once more. Allocations never fail
+// because of the limit. A limit of 0, the default, turns this off.
+func SetMemoryLimit(limit uint64) {
+	memoryLimit.Store(limit)
+}
+
+// account updates the count of live bytes after the block's capacity
+// changed.
+func (this *Memory) account() {
+	if _, mapped := this.backing.(*fileBacking); mapped || this.capacity == this.accounted {
+		return
+	}
+	if this.capacity < this.accounted {
+		this.unaccount()
+	}
+	live := liveBytes.Add(this.capacity - this.accounted)
+	this.accounted = this.capacity
+	applyPressure(live)
+}
+
+// unaccount removes the block's bytes from the count of live bytes.
+func (this *Memory) unaccount() {
+	if this.accounted == 0 {
+		return
+	}
+	live := liveBytes.Add(-this.accounted)
+	this.accounted = 0
+	for {
+		baseline := gcBaseline.Load()
+		if live >= baseline || gcBaseline.CompareAndSwap(baseline, live) {
+			return
+		}
+	}
+}
+
+// applyPressure runs the garbage collector if live has crossed the threshold
+// or the limit. The limit only counts as crossed if the count was under it
+// after the last collection.
+func applyPressure(live uint64) {
+	if limit := memoryLimit.Load(); limit != 0 && live > limit && gcBaseline.Load() <= limit {
+		// Finalizers run on their own goroutine after a collection, so
+		// give them a moment to catch up.
+		for attempt := 0; attempt < 3 && liveBytes.Load() > limit; attempt++ {
+			runtime.GC()
+			time.Sleep(time.Millisecond << attempt)
+		}
+		gcBaseline.Store(liveBytes.Load())
+		return
+	}
+	threshold := gcThreshold.Load()
+	if threshold == 0 || live-min(live, gcBaseline.Load()) < threshold {
+		return
+	}
+	if collecting.CompareAndSwap(false, true) {
+		go func() {
+			runtime.GC()
+			gcBaseline.Store(liveBytes.Load())
+			collecting.Store(false)
+		}()
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestLiveBytes(t *testing.T) {
+	mem, _ := Alloc(1000)
+	if mem.accounted != 1000 || LiveBytes() < 1000 {
+		t.Error("Alloc() didn't count the block")
+	}
+	mem.Grow(3000)
+	if mem.accounted != 3000 {
+		t.Error("Grow() didn't update the count")
+	}
+	mem.Truncate(10)
+	if mem.accounted != mem.Capacity() {
+		t.Error("Truncate() didn't update the count")
+	}
+	mem.Close()
+	if mem.accounted != 0 {
+		t.Error("Close() didn't remove the block from the count")
+	}
+
+	detached, _ := Alloc(100)
+	testFree(detached.Detach())
+	if detached.accounted != 0 {
+		t.Error("Detach() didn't remove the block from the count")
+	}
+	if borrowed := WrapBorrowed(unsafe.Pointer(&t), 8); borrowed.accounted != 0 {
+		t.Error("WrapBorrowed() counted a block it doesn't own")
+	}
+
+	path := filepath.Join(t.TempDir(), "mapped")
+	os.WriteFile(path, make([]byte, 4096), 0600)
+	mapping, err := MapFile(path, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	mapping.Grow(8192)
+	if mapping.accounted != 0 {
+		t.Error("File mappings were counted")
+	}
+}
+
+// dropBlocks allocates count blocks of size bytes without closing them, and
+// returns a counter of how many have been finalized.
+func dropBlocks(count int, size uint64) *atomic.Int64 {
+	finalized := new(atomic.Int64)
+	for i := 0; i < count; i++ {
+		WrapMemoryFunc(testMalloc(size), size, func(cbuf unsafe.Pointer) {
+			testFree(cbuf)
+			finalized.Add(1)
+		})
+	}
+	return finalized
+}
+
+func TestGCThreshold(t *testing.T) {
+	defer SetGCThreshold(DefaultGCThreshold)
+	SetGCThreshold(1 << 20)
+	finalized := dropBlocks(64, 1<<20)
+	for i := 0; i < 200 && finalized.Load() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if finalized.Load() == 0 {
+		t.Error("Crossing the threshold didn't start a collection")
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	defer SetGCThreshold(DefaultGCThreshold)
+	defer SetMemoryLimit(0)
+	SetGCThreshold(0)
+	SetMemoryLimit(LiveBytes() + 8<<20)
+	finalized := dropBlocks(32, 1<<20)
+	if finalized.Load() == 0 {
+		t.Error("Going over the limit didn't free unreachable blocks")
+	}
+}
+
+func TestMemoryLimitOnce(t *testing.T) {
+	defer SetGCThreshold(DefaultGCThreshold)
+	defer SetMemoryLimit(0)
+	SetGCThreshold(0)
+	SetMemoryLimit(LiveBytes() + 1<<20)
+	held, _ := Alloc(2 << 20)
+	defer held.Close()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	collections := stats.NumGC
+	for i := 0; i < 20; i++ {
+		mem, _ := Alloc(64)
+		defer mem.Close()
+	}
+	runtime.ReadMemStats(&stats)
+	if stats.NumGC-collections > 3 {
+		t.Errorf("%d collections while staying over the limit", stats.NumGC-collections)
+	}
+}
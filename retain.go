@@ -0,0 +1,89 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+var ErrInUse = errors.New("Memory block is retained and cannot be moved")
+var ErrNotRetained = errors.New("Memory block is not retained")
+
+// retained holds the retain count of each retained Memory. Being in the map
+// also keeps a Memory reachable, so it can't be finalized while C code is using
+// its block.
+var retained = make(map[*Memory]uint64)
+var retainMutex sync.Mutex
+
+// owner returns the Memory that owns the block a section was taken from.
+func (this *Memory) owner() *Memory {
+	owner := this
+	for owner.parent != nil {
+		owner = owner.parent
+	}
+	return owner
+}
+
+// inUse returns whether the block is retained.
+func (this *Memory) inUse() bool {
+	retainMutex.Lock()
+	defer retainMutex.Unlock()
+	return retained[this.owner()] != 0
+}
+
+// Retain returns Cbuf and keeps the block alive and in place until a matching
+// call to Release, for C code that holds on to the pointer after the call that
+// received it returns. Until then the Memory can't be finalized, Grow() and
+// the other methods that would move the block return ErrInUse, and Close()
+// only marks it closed, leaving the last Release to free it. Retain and Release
+// are safe to call from any goroutine, and calls can be nested. Retaining a
+// section retains the block it was taken from.
+func (this *Memory) Retain() (unsafe.Pointer, error) {
+	retainMutex.Lock()
+	defer retainMutex.Unlock()
+	if this.isClosed() {
+		return nil, ErrClosed
+	}
+	retained[this.owner()] += 1
+	return this.Cbuf, nil
+}
+
+// Release undoes one call to Retain. If the Memory was closed in the meantime
+// and this is the last Release, the block is freed.
+func (this *Memory) Release() error {
+	owner := this.owner()
+	retainMutex.Lock()
+	count := retained[owner]
+	if count == 0 {
+		retainMutex.Unlock()
+		return ErrNotRetained
+	}
+	if count > 1 {
+		retained[owner] = count - 1
+		retainMutex.Unlock()
+		return nil
+	}
+	delete(retained, owner)
+	closePending := owner.closed
+	retainMutex.Unlock()
+	if closePending {
+		owner.release()
+	}
+	return nil
+}
+
+// With calls f with Cbuf and Size, and keeps the block alive and in place
+// until it returns, as if it were wrapped in Retain and Release. Passing
+// mem.Cbuf to C directly doesn't keep mem reachable, so it can be finalized
+// in the middle of the call; passing p from inside With is safe.
+func (this *Memory) With(f func(p unsafe.Pointer, n uint64)) error {
+	p, err := this.Retain()
+	if err != nil {
+		return err
+	}
+	defer this.Release()
+	f(p, this.Size)
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestRetain(t *testing.T) {
+	freed := 0
+	mem := WrapMemoryFunc(testMalloc(64), 64, func(cbuf unsafe.Pointer) {
+		testFree(cbuf)
+		freed += 1
+	})
+	pointer, err := mem.Retain()
+	if err != nil || pointer != mem.Cbuf {
+		t.Fatal("Retain() didn't return the block")
+	}
+	mem.Retain()
+	if err := mem.Grow(128); err != ErrInUse {
+		t.Error("Grow() moved a retained block")
+	}
+	mem.Close()
+	if _, err := mem.Retain(); err != ErrClosed {
+		t.Error("Retain() worked after Close()")
+	}
+	mem.Release()
+	if freed != 0 {
+		t.Error("Block freed while still retained")
+	}
+	mem.Release()
+	if freed != 1 {
+		t.Error("Last Release() didn't free the closed block")
+	}
+	if err := mem.Release(); err != ErrNotRetained {
+		t.Error("Release() without Retain() didn't return ErrNotRetained")
+	}
+
+	parent, _ := Alloc(64)
+	defer parent.Close()
+	section, _ := parent.Section(8, 8)
+	section.Retain()
+	if err := parent.Grow(128); err != ErrInUse {
+		t.Error("Retaining a section didn't retain its parent")
+	}
+	section.Release()
+}
+
+func TestRetainKeepsAlive(t *testing.T) {
+	freed := make(chan struct{}, 1)
+	mem := WrapMemoryFunc(testMalloc(64), 64, func(cbuf unsafe.Pointer) {
+		testFree(cbuf)
+		freed <- struct{}{}
+	})
+	pointer, _ := mem.Retain()
+	mem = nil
+	runtime.GC()
+	runtime.GC()
+	select {
+	case <-freed:
+		t.Fatal("Retained block was finalized")
+	default:
+	}
+	retainMutex.Lock()
+	for retainedMemory := range retained {
+		if retainedMemory.Cbuf == pointer {
+			mem = retainedMemory
+		}
+	}
+	retainMutex.Unlock()
+	mem.Release()
+	mem.Close()
+}
+
+func TestWith(t *testing.T) {
+	buffer, _ := AllocBuffer(4)
+	defer buffer.Close()
+	buffer.Write([]byte("abcd"))
+	err := buffer.With(func(p unsafe.Pointer, n uint64) {
+		if n != 4 || p != buffer.Cbuf {
+			t.Error("With() passed the wrong block")
+		}
+		if _, err := buffer.Write([]byte("e")); err != ErrInUse {
+			t.Error("Write() grew the block inside With()")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buffer.Write([]byte("e")); err != nil {
+		t.Error("Block still retained after With() returned")
+	}
+}
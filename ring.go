@@ -0,0 +1,268 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+/*
+#include "ring.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var ErrWouldBlock = errors.New("Ring buffer operation would block")
+var ErrNotRing = errors.New("Memory does not hold a ring buffer")
+var ErrRingCapacity = errors.New("Ring buffer capacity must be a nonzero power of two")
+var ErrRingStalled = errors.New("Ring buffer is broken by a write that stalled")
+
+// ringPublishTimeout is how long Write waits for earlier producers to publish
+// their writes, the same as ring_try_write in C.
+var ringPublishTimeout = time.Duration(C.RING_PUBLISH_TIMEOUT_NS)
+
+// RingHeaderSize is the size of the header in front of a ring's data. The
+// header format is documented in ring.h.
+const RingHeaderSize = C.RING_HEADER_SIZE
+
+// Ring is a ring buffer of bytes stored in a Memory, starting with the header
+// described in ring.h. C code can produce into it or consume from it with the
+// functions in ring.h, and when the Memory is shared memory, so can other
+// processes. Any number of producers can write at once; writes of up to
+// Capacity() bytes are never interleaved with other producers' writes. Only
+// one consumer can read at a time, though Read is safe to call from several
+// goroutines sharing a Ring.
+//
+// The ring is not lock-free: producers claim space without locks but publish
+// in order, so a write waits for earlier writes to finish. If a producer stalls
+// or dies in the middle of a write for longer than a second, the producers
+// behind it mark the ring as broken, and from then on Write, and Read once the
+// data before the stalled write has been read, return ErrRingStalled. A broken
+// ring has to be created again with NewRing.
+//
+// By default Read waits for data and Write waits for room. In nonblocking mode
+// they return ErrWouldBlock instead when the ring is full; once space has been
+// claimed, Write still waits for earlier writes to be published.
+type Ring struct {
+	mem         *Memory
+	offset      uint64
+	capacity    uint64
+	nonblocking bool
+	readMutex   sync.Mutex
+}
+
+// RingSize returns the number of bytes a ring with the given capacity takes up.
+func RingSize(capacity uint64) uint64 {
+	return RingHeaderSize + capacity
+}
+
+// checkRing checks that a ring header fits at offset and is aligned.
+func checkRing(mem *Memory, offset uint64, capacity uint64) error {
+	if err := mem.writable(); err != nil {
+		return err
+	}
+	if offset > mem.Size || mem.Size-offset < RingSize(capacity) {
+		return ErrOutOfRange
+	}
+	if uintptr(unsafe.Add(mem.Cbuf, offset))%8 != 0 {
+		return ErrMisaligned
+	}
+	return nil
+}
+
+// NewRing creates an empty ring with capacity bytes of data at offset in mem,
+// overwriting whatever was there. It takes up RingSize(capacity) bytes, and
+// offset has to be 8-byte aligned.
+func NewRing(mem *Memory, offset uint64, capacity uint64) (*Ring, error) {
+	if capacity == 0 || capacity&(capacity-1) != 0 {
+		return nil, ErrRingCapacity
+	}
+	err := checkRing(mem, offset, capacity)
+	if err != nil {
+		return nil, err
+	}
+	header := (*C.struct_ring_header)(unsafe.Add(mem.Cbuf, offset))
+	clear(unsafe.Slice((*byte)(unsafe.Pointer(header)), RingHeaderSize))
+	header.version = C.RING_VERSION
+	header.capacity = C.uint64_t(capacity)
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&header.magic)), C.RING_MAGIC)
+	return &Ring{mem: mem, offset: offset, capacity: capacity}, nil
+}
+
+// OpenRing attaches to a ring that was already created at offset in mem, by
+// NewRing in this or another process or by C code following ring.h.
+func OpenRing(mem *Memory, offset uint64) (*Ring, error) {
+	err := checkRing(mem, offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	header := (*C.struct_ring_header)(unsafe.Add(mem.Cbuf, offset))
+	if atomic.LoadUint32((*uint32)(unsafe.Pointer(&header.magic))) != C.RING_MAGIC || header.version != C.RING_VERSION {
+		return nil, ErrNotRing
+	}
+	capacity := uint64(header.capacity)
+	if capacity == 0 || capacity&(capacity-1) != 0 {
+		return nil, ErrNotRing
+	}
+	err = checkRing(mem, offset, capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &Ring{mem: mem, offset: offset, capacity: capacity}, nil
+}
+
+// header returns the ring's header. It is looked up every time since Grow()
+// can move the block.
+func (this *Ring) header() (*C.struct_ring_header, error) {
+	if err := this.mem.readable(); err != nil {
+		return nil, err
+	}
+	return (*C.struct_ring_header)(unsafe.Add(this.mem.Cbuf, this.offset)), nil
+}
+
+// Capacity returns the number of bytes the ring can hold.
+func (this *Ring) Capacity() uint64 {
+	return this.capacity
+}
+
+// Buffered returns the number of bytes waiting to be read.
+func (this *Ring) Buffered() uint64 {
+	header, err := this.header()
+	if err != nil {
+		return 0
+	}
+	read := atomic.LoadUint64((*uint64)(unsafe.Pointer(&header.read)))
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(&header.write))) - read
+}
+
+// SetNonblocking switches between blocking and nonblocking mode.
+func (this *Ring) SetNonblocking(nonblocking bool) {
+	this.nonblocking = nonblocking
+}
+
+// Memory returns the Memory that holds the ring.
+func (this *Ring) Memory() *Memory {
+	return this.mem
+}
+
+// backoff waits a little before the next attempt at an operation. The other
+// side may be in another process, so there's nothing to wait on but time.
+func backoff(attempt int) {
+	if attempt < 16 {
+		runtime.Gosched()
+		return
+	}
+	delay := time.Microsecond << min(attempt-16, 10)
+	time.Sleep(delay)
+}
+
+// Read implements the io.Reader interface to read from the ring. It returns
+// io.EOF once the ring has been closed with CloseWrite() and is empty.
+func (this *Ring) Read(output []byte) (int, error) {
+	this.readMutex.Lock()
+	defer this.readMutex.Unlock()
+	if len(output) == 0 {
+		return 0, nil
+	}
+	for attempt := 0; ; attempt++ {
+		header, err := this.header()
+		if err != nil {
+			return 0, err
+		}
+		// Check for closing first, so that data written just before it
+		// isn't missed.
+		closed := C.ring_closed(header) != 0
+		broken := C.ring_broken(header) != 0
+		read := C.ring_read(header, unsafe.Pointer(&output[0]), C.size_t(len(output)))
+		if read > 0 {
+			return int(read), nil
+		}
+		if broken {
+			return 0, ErrRingStalled
+		}
+		if closed {
+			return 0, io.EOF
+		}
+		if this.nonblocking {
+			return 0, ErrWouldBlock
+		}
+		backoff(attempt)
+	}
+}
+
+// Write implements the io.Writer interface to write to the ring. Writing to a
+// ring closed with CloseWrite() returns io.ErrClosedPipe.
+func (this *Ring) Write(input []byte) (int, error) {
+	written := 0
+	for attempt := 0; written < len(input); {
+		header, err := this.header()
+		if err != nil {
+			return written, err
+		}
+		if C.ring_closed(header) != 0 {
+			return written, io.ErrClosedPipe
+		}
+		if C.ring_broken(header) != 0 {
+			return written, ErrRingStalled
+		}
+		chunk := input[written:]
+		if uint64(len(chunk)) > this.capacity {
+			chunk = chunk[:this.capacity]
+		}
+		var start C.uint64_t
+		switch C.ring_reserve(header, unsafe.Pointer(&chunk[0]), C.size_t(len(chunk)), &start) {
+		case 0:
+			err := publish(header, start, len(chunk))
+			if err != nil {
+				return written, err
+			}
+			written += len(chunk)
+			attempt = 0
+			continue
+		case C.RING_STALLED:
+			return written, ErrRingStalled
+		}
+		if this.nonblocking {
+			return written, ErrWouldBlock
+		}
+		backoff(attempt)
+		attempt++
+	}
+	return written, nil
+}
+
+// publish waits for earlier producers to publish their writes and then
+// publishes length bytes at start, like ring_write_timeout does in C but
+// without spinning inside a C call.
+func publish(header *C.struct_ring_header, start C.uint64_t, length int) error {
+	deadline := time.Now().Add(ringPublishTimeout)
+	for attempt := 0; ; attempt++ {
+		switch C.ring_try_publish(header, start, C.size_t(length)) {
+		case 0:
+			return nil
+		case C.RING_STALLED:
+			return ErrRingStalled
+		}
+		if time.Now().After(deadline) {
+			C.ring_break(header)
+			return ErrRingStalled
+		}
+		backoff(attempt)
+	}
+}
+
+// CloseWrite marks the ring as closed, so that readers get io.EOF once they
+// have read everything in it. It doesn't free the Memory.
+func (this *Ring) CloseWrite() error {
+	header, err := this.header()
+	if err != nil {
+		return err
+	}
+	C.ring_close(header)
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright © 2014 Emily Maier
+
+// C side of the cmemory ring buffer. Include this header in C code that
+// produces into or consumes from a ring created with cmemory.NewRing.
+//
+// A ring is a RING_HEADER_SIZE byte header followed by capacity bytes of data,
+// where capacity is a power of two. The header holds, in native byte order:
+//
+//	offset   0: uint32_t magic, RING_MAGIC
+//	offset   4: uint32_t version, RING_VERSION
+//	offset   8: uint64_t capacity
+//	offset  16: uint32_t flags, RING_CLOSED once the producers are done and
+//	            RING_BROKEN once a write has stalled
+//	offset  64: uint64_t reserve, the end of the space claimed by producers
+//	offset 128: uint64_t write, the end of the data that consumers can read
+//	offset 192: uint64_t read, the end of the data already consumed
+//
+// The positions only ever increase, and byte n of the stream is stored at
+// data[n & (capacity - 1)]. Each position sits in its own cache line. Any
+// number of producers can write at once, but only one consumer can read.
+// Everything is accessed with atomics, so the ring works between threads or,
+// in shared memory, between processes.
+//
+// The ring is not lock-free. Producers claim space without locks, but publish
+// their writes in the order they claimed it: a producer waits for the ones
+// before it to finish. If a producer stalls or dies between claiming space and
+// publishing, the producers behind it give up after RING_PUBLISH_TIMEOUT_NS,
+// mark the ring RING_BROKEN, and return RING_STALLED. A broken ring stays
+// broken; its data up to the stalled write can still be read, and then it has
+// to be created again.
+
+#ifndef CMEMORY_RING_H
+#define CMEMORY_RING_H
+
+#include <sched.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <string.h>
+#include <time.h>
+
+#define RING_MAGIC 0x42524d43
+#define RING_VERSION 1
+#define RING_HEADER_SIZE 256
+#define RING_CLOSED 1
+#define RING_BROKEN 2
+
+// Results of the write functions besides 0 for success.
+#define RING_FULL -1
+#define RING_STALLED -2
+
+// How long ring_try_write waits for earlier producers to publish, in
+// nanoseconds.
+#define RING_PUBLISH_TIMEOUT_NS 1000000000
+
+struct ring_header
+{
+	uint32_t magic;
+	uint32_t version;
+	uint64_t capacity;
+	uint32_t flags;
+	char pad0[64 - 20];
+	uint64_t reserve;
+	char pad1[64 - 8];
+	uint64_t write;
+	char pad2[64 - 8];
+	uint64_t read;
+	char pad3[64 - 8];
+};
+
+static inline unsigned char* ring_data(struct ring_header* ring)
+{
+	return (unsigned char*)ring + RING_HEADER_SIZE;
+}
+
+// Marks the ring as broken because a write stalled.
+static inline void ring_break(struct ring_header* ring)
+{
+	__atomic_fetch_or(&ring->flags, RING_BROKEN, __ATOMIC_RELEASE);
+}
+
+// Returns whether the ring has been marked as broken.
+static inline int ring_broken(struct ring_header* ring)
+{
+	return (__atomic_load_n(&ring->flags, __ATOMIC_ACQUIRE) & RING_BROKEN) != 0;
+}
+
+// Claims length bytes of the ring and copies buf into them, without
+// publishing them to the consumer yet. Returns 0 and stores the start of the
+// claimed space in *start_out on success, RING_FULL if the ring is too full,
+// or RING_STALLED if it is broken; in both cases nothing is written. Every
+// successful call has to be followed by ring_try_publish until it doesn't
+// return RING_FULL.
+static inline int ring_reserve(struct ring_header* ring, const void* buf, size_t length, uint64_t* start_out)
+{
+	if(ring_broken(ring))
+	{
+		return RING_STALLED;
+	}
+	uint64_t capacity = ring->capacity;
+	uint64_t start = __atomic_load_n(&ring->reserve, __ATOMIC_ACQUIRE);
+	do
+	{
+		uint64_t read = __atomic_load_n(&ring->read, __ATOMIC_ACQUIRE);
+		if(capacity - (start - read) < length)
+		{
+			return RING_FULL;
+		}
+	}
+	while(!__atomic_compare_exchange_n(&ring->reserve, &start, start + length, 0, __ATOMIC_ACQ_REL, __ATOMIC_ACQUIRE));
+
+	uint64_t index = start & (capacity - 1);
+	size_t first = length < capacity - index ? length : capacity - index;
+	memcpy(ring_data(ring) + index, buf, first);
+	memcpy(ring_data(ring), (const unsigned char*)buf + first, length - first);
+	*start_out = start;
+	return 0;
+}
+
+// Publishes the length bytes claimed at start by ring_reserve. Returns 0 on
+// success, RING_FULL if earlier reservations haven't been published yet, in
+// which case it has to be called again later, or RING_STALLED if the ring has
+// been marked as broken in the meantime.
+static inline int ring_try_publish(struct ring_header* ring, uint64_t start, size_t length)
+{
+	if(ring_broken(ring))
+	{
+		return RING_STALLED;
+	}
+	if(__atomic_load_n(&ring->write, __ATOMIC_ACQUIRE) != start)
+	{
+		return RING_FULL;
+	}
+	__atomic_store_n(&ring->write, start + length, __ATOMIC_RELEASE);
+	return 0;
+}
+
+// Returns the monotonic clock in nanoseconds.
+static inline uint64_t ring_now(void)
+{
+	struct timespec now;
+	clock_gettime(CLOCK_MONOTONIC, &now);
+	return (uint64_t)now.tv_sec * 1000000000 + now.tv_nsec;
+}
+
+// Writes all length bytes to the ring if there is room for them, without
+// interleaving them with other producers' writes. Returns 0 on success and
+// RING_FULL if the ring is too full, in which case nothing is written. Waits
+// up to timeout_ns nanoseconds for earlier producers to publish their writes;
+// if they don't, the ring is marked as broken and RING_STALLED is returned.
+static inline int ring_write_timeout(struct ring_header* ring, const void* buf, size_t length, uint64_t timeout_ns)
+{
+	uint64_t start;
+	int result = ring_reserve(ring, buf, length, &start);
+	if(result != 0)
+	{
+		return result;
+	}
+	uint64_t deadline = 0;
+	for(int attempt = 0; ; attempt++)
+	{
+		result = ring_try_publish(ring, start, length);
+		if(result != RING_FULL)
+		{
+			return result;
+		}
+		if(attempt < 64)
+		{
+			continue;
+		}
+		if(deadline == 0)
+		{
+			deadline = ring_now() + timeout_ns;
+		}
+		else if(ring_now() >= deadline)
+		{
+			ring_break(ring);
+			return RING_STALLED;
+		}
+		sched_yield();
+	}
+}
+
+// ring_write_timeout with a timeout of RING_PUBLISH_TIMEOUT_NS.
+static inline int ring_try_write(struct ring_header* ring, const void* buf, size_t length)
+{
+	return ring_write_timeout(ring, buf, length, RING_PUBLISH_TIMEOUT_NS);
+}
+
+// Reads up to length bytes from the ring and returns how many were read,
+// which is 0 if the ring is empty. Only one consumer can call it at a time. An
+// empty ring that is broken will never get more data.
+static inline size_t ring_read(struct ring_header* ring, void* buf, size_t length)
+{
+	uint64_t capacity = ring->capacity;
+	uint64_t read = __atomic_load_n(&ring->read, __ATOMIC_ACQUIRE);
+	uint64_t available = __atomic_load_n(&ring->write, __ATOMIC_ACQUIRE) - read;
+	if(length > available)
+	{
+		length = available;
+	}
+	uint64_t index = read & (capacity - 1);
+	size_t first = length < capacity - index ? length : capacity - index;
+	memcpy(buf, ring_data(ring) + index, first);
+	memcpy((unsigned char*)buf + first, ring_data(ring), length - first);
+	__atomic_store_n(&ring->read, read + length, __ATOMIC_RELEASE);
+	return length;
+}
+
+// Marks the ring as closed, so that readers get end of file once it is empty.
+static inline void ring_close(struct ring_header* ring)
+{
+	__atomic_fetch_or(&ring->flags, RING_CLOSED, __ATOMIC_RELEASE);
+}
+
+// Returns whether the ring has been closed.
+static inline int ring_closed(struct ring_header* ring)
+{
+	return (__atomic_load_n(&ring->flags, __ATOMIC_ACQUIRE) & RING_CLOSED) != 0;
+}
+
+#endif
@@ -0,0 +1,196 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestRingReadWrite(t *testing.T) {
+	mem, _ := AllocAligned(RingSize(16), 64)
+	defer mem.Close()
+	ring, err := NewRing(mem, 0, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.SetNonblocking(true)
+	output := make([]byte, 16)
+	if _, err := ring.Read(output); err != ErrWouldBlock {
+		t.Error("Read() from an empty ring didn't return ErrWouldBlock")
+	}
+	// Go around the ring several times so that reads and writes wrap.
+	for i := 0; i < 10; i++ {
+		input := []byte("abcdefghijk")[:i+1]
+		written, err := ring.Write(input)
+		if written != len(input) || err != nil {
+			t.Fatal("Write() failed")
+		}
+		if ring.Buffered() != uint64(len(input)) {
+			t.Error("Buffered() returned the wrong size")
+		}
+		read, _ := ring.Read(output)
+		if !bytes.Equal(output[:read], input) {
+			t.Errorf("Read() returned %q, expected %q", output[:read], input)
+		}
+	}
+	ring.Write(make([]byte, 10))
+	if written, err := ring.Write(make([]byte, 7)); written != 0 || err != ErrWouldBlock {
+		t.Error("Write() to a full ring didn't return ErrWouldBlock")
+	}
+	ring.CloseWrite()
+	if _, err := ring.Write([]byte{1}); err != io.ErrClosedPipe {
+		t.Error("Write() after CloseWrite() didn't return io.ErrClosedPipe")
+	}
+	if read, _ := ring.Read(output); read != 10 {
+		t.Error("Read() didn't drain the closed ring")
+	}
+	if _, err := ring.Read(output); err != io.EOF {
+		t.Error("Read() from a drained closed ring didn't return io.EOF")
+	}
+}
+
+func TestRingErrors(t *testing.T) {
+	mem, _ := AllocAligned(RingSize(64)+8, 64)
+	defer mem.Close()
+	if _, err := NewRing(mem, 0, 48); err != ErrRingCapacity {
+		t.Error("NewRing() accepted a capacity that isn't a power of two")
+	}
+	if _, err := NewRing(mem, 4, 64); err != ErrMisaligned {
+		t.Error("NewRing() accepted a misaligned offset")
+	}
+	if _, err := NewRing(mem, 16, 64); err != ErrOutOfRange {
+		t.Error("NewRing() went past the end of the block")
+	}
+	clear(mem.Bytes())
+	if _, err := OpenRing(mem, 0); err != ErrNotRing {
+		t.Error("OpenRing() accepted a block without a ring")
+	}
+	ring, _ := NewRing(mem, 8, 64)
+	opened, err := OpenRing(mem, 8)
+	if err != nil || opened.Capacity() != 64 {
+		t.Error("OpenRing() failed to attach")
+	}
+	mem.Close()
+	if _, err := ring.Write([]byte{1}); err != ErrClosed {
+		t.Error("Write() after Close() didn't return ErrClosed")
+	}
+}
+
+func TestRingProducers(t *testing.T) {
+	const producers, count = 4, 10000
+	mem, _ := AllocAligned(RingSize(64), 64)
+	defer mem.Close()
+	ring, _ := NewRing(mem, 0, 64)
+	var group sync.WaitGroup
+	for producer := 0; producer < producers; producer++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			record := make([]byte, 8)
+			for i := 0; i < count; i++ {
+				binary.NativeEndian.PutUint32(record, uint32(producer))
+				binary.NativeEndian.PutUint32(record[4:], uint32(i))
+				ring.Write(record)
+			}
+		}()
+	}
+	go func() {
+		group.Wait()
+		ring.CloseWrite()
+	}()
+
+	// Records have to arrive whole and in order for each producer.
+	next := make([]uint32, producers)
+	data, err := io.ReadAll(ring)
+	if err != nil || len(data) != producers*count*8 {
+		t.Fatalf("Read %d bytes, %v", len(data), err)
+	}
+	for offset := 0; offset < len(data); offset += 8 {
+		producer := binary.NativeEndian.Uint32(data[offset:])
+		if binary.NativeEndian.Uint32(data[offset+4:]) != next[producer] {
+			t.Fatal("Records were interleaved or reordered")
+		}
+		next[producer] += 1
+	}
+}
+
+func TestRingFromC(t *testing.T) {
+	const count = 10000
+	mem, _ := AllocAligned(RingSize(256), 64)
+	defer mem.Close()
+	ring, _ := NewRing(mem, 0, 256)
+	go testRingProduce(mem.Cbuf, count)
+	var value uint64
+	for i := uint64(0); i < count; i++ {
+		_, err := io.ReadFull(ring, (*[8]byte)(unsafe.Pointer(&value))[:])
+		if err != nil || value != i {
+			t.Fatalf("Read %d from C, expected %d", value, i)
+		}
+	}
+}
+
+func TestRingShared(t *testing.T) {
+	mem, err := AllocShared(RingSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	producer, _ := NewRing(mem, 0, 4096)
+	fd, _ := mem.Fd()
+	dup, _ := syscall.Dup(fd)
+	other, err := MapFd(dup, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	consumer, err := OpenRing(other, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer.Write([]byte("across mappings"))
+	producer.CloseWrite()
+	data, _ := io.ReadAll(consumer)
+	if string(data) != "across mappings" {
+		t.Error("Ring did not work across mappings of shared memory")
+	}
+}
+
+func TestRingStalled(t *testing.T) {
+	defer func(timeout time.Duration) { ringPublishTimeout = timeout }(ringPublishTimeout)
+	ringPublishTimeout = 10 * time.Millisecond
+	mem, _ := AllocAligned(RingSize(64), 64)
+	defer mem.Close()
+	ring, _ := NewRing(mem, 0, 64)
+	ring.Write([]byte("before"))
+	testRingReserve(mem.Cbuf, 8)
+	if !testRingWriteStalled(mem.Cbuf, []byte{1}, 10*time.Millisecond) {
+		t.Error("ring_write_timeout() didn't time out behind a stalled write")
+	}
+	if _, err := ring.Write([]byte{1}); err != ErrRingStalled {
+		t.Error("Write() to a broken ring didn't return ErrRingStalled")
+	}
+	data := make([]byte, 16)
+	bytesRead, err := ring.Read(data)
+	if err != nil || string(data[:bytesRead]) != "before" {
+		t.Error("Data before the stalled write was lost")
+	}
+	if _, err := ring.Read(data); err != ErrRingStalled {
+		t.Error("Read() from a broken ring didn't return ErrRingStalled")
+	}
+
+	mem2, _ := AllocAligned(RingSize(64), 64)
+	defer mem2.Close()
+	ring, _ = NewRing(mem2, 0, 64)
+	testRingReserve(mem2.Cbuf, 8)
+	if _, err := ring.Write([]byte{1}); err != ErrRingStalled {
+		t.Error("Write() behind a stalled write didn't time out")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"context"
+	"sync"
+	"unsafe"
+)
+
+// Scope collects Memory blocks so that they can all be freed at once, instead
+// of deferring a Close() for each one or waiting for the garbage collector.
+// Blocks are closed in the reverse of the order they were added. A Scope is
+// safe for concurrent use.
+type Scope struct {
+	mutex  sync.Mutex
+	blocks []*Memory
+	closed bool
+	stop   func() bool
+}
+
+// NewScope creates an empty Scope.
+func NewScope() *Scope {
+	return new(Scope)
+}
+
+// NewScopeContext creates a Scope that is closed when ctx is cancelled, in
+// addition to when Close() is called. The blocks are freed from another
+// goroutine at that point, so code using them has to stop when ctx is done.
+func NewScopeContext(ctx context.Context) *Scope {
+	newScope := new(Scope)
+	newScope.stop = context.AfterFunc(ctx, func() {
+		newScope.Close()
+	})
+	return newScope
+}
+
+// Add registers mem with the scope, so that it is closed along with it. If the
+// scope is already closed, mem is closed right away and ErrClosed is returned.
+func (this *Scope) Add(mem *Memory) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		mem.Close()
+		return ErrClosed
+	}
+	this.blocks = append(this.blocks, mem)
+	return nil
+}
+
+// add registers a newly created block unless creating it failed.
+func (this *Scope) add(mem *Memory, err error) (*Memory, error) {
+	if err != nil {
+		return mem, err
+	}
+	return mem, this.Add(mem)
+}
+
+// Alloc is like the package's Alloc, but the block belongs to the scope.
+func (this *Scope) Alloc(size uint64) (*Memory, error) {
+	return this.add(Alloc(size))
+}
+
+// AllocFromSlice is like the package's AllocFromSlice, but the block belongs
+// to the scope.
+func (this *Scope) AllocFromSlice(data []byte) (*Memory, error) {
+	return this.add(AllocFromSlice(data))
+}
+
+// WrapMemory is like the package's WrapMemory, but the block belongs to the
+// scope.
+func (this *Scope) WrapMemory(cbuf unsafe.Pointer, size uint64) (*Memory, error) {
+	return this.add(WrapMemory(cbuf, size), nil)
+}
+
+// Close closes every block in the scope, newest first. Blocks that were
+// already closed are skipped. Later calls do nothing.
+func (this *Scope) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	if this.stop != nil {
+		this.stop()
+	}
+	for index := len(this.blocks) - 1; index >= 0; index-- {
+		this.blocks[index].Close()
+	}
+	this.blocks = nil
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"context"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestScope(t *testing.T) {
+	scope := NewScope()
+	order := make([]int, 0)
+	for i := 0; i < 3; i++ {
+		mem := WrapMemoryFunc(testMalloc(8), 8, func(cbuf unsafe.Pointer) {
+			order = append(order, i)
+			WrapMemory(cbuf, 8).Close()
+		})
+		if err := scope.Add(mem); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allocated, err := scope.Alloc(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, _ := scope.AllocFromSlice([]byte("data"))
+	wrapped, _ := scope.WrapMemory(testMalloc(4), 4)
+	copied.Close()
+	scope.Close()
+	if len(order) != 3 || order[0] != 2 || order[2] != 0 {
+		t.Errorf("Scope closed blocks in order %v", order)
+	}
+	if !allocated.isClosed() || !wrapped.isClosed() {
+		t.Error("Close() didn't close every block")
+	}
+	scope.Close()
+	if _, err := scope.Alloc(16); err != ErrClosed {
+		t.Error("Alloc() on a closed scope didn't return ErrClosed")
+	}
+}
+
+func TestScopeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	scope := NewScopeContext(ctx)
+	closed := make(chan struct{})
+	scope.Add(WrapMemoryFunc(testMalloc(8), 8, func(cbuf unsafe.Pointer) {
+		WrapMemory(cbuf, 8).Close()
+		close(closed)
+	}))
+	cancel()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelling the context didn't close the scope")
+	}
+	if _, err := scope.Alloc(8); err != ErrClosed {
+		t.Error("Alloc() after cancellation didn't return ErrClosed")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import "unsafe"
+
+// Section returns a Memory covering length bytes of this block starting at
+// offset, with its own cursor and bounds. The section borrows the block: it
+// never frees it, and it keeps this Memory from being finalized while it is in
+// use. If this Memory is grown, the section follows the block to its new
+// address; if it is closed, or shrunk so that the section no longer fits, the
+// section's methods return ErrClosed. Cbuf of the section is only updated by
+// its methods, so it has to be read again after a call.
+func (this *Memory) Section(offset uint64, length uint64) (*Memory, error) {
+	if this.isClosed() {
+		return nil, ErrClosed
+	}
+	if offset > this.Size || length > this.Size-offset {
+		return nil, ErrOutOfRange
+	}
+	section := WrapBorrowed(unsafe.Add(this.Cbuf, offset), length)
+	section.parent = this
+	section.offset = offset
+	return section, nil
+}
+
+// NewReader returns a read-only section over the whole block, so that it can
+// be read independently of this Memory's cursor.
+func (this *Memory) NewReader() (*Memory, error) {
+	reader, err := this.Section(0, this.Size)
+	if err != nil {
+		return nil, err
+	}
+	reader.readOnly = true
+	return reader, nil
+}
+
+// NewWriter returns a section over the whole block, so that it can be written
+// independently of this Memory's cursor.
+func (this *Memory) NewWriter() (*Memory, error) {
+	return this.Section(0, this.Size)
+}
@@ -0,0 +1,98 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestSection(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	section, err := mem.Section(100, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if section.Owned() {
+		t.Error("Section() took ownership of the block")
+	}
+	readByte, _ := section.ReadByte()
+	if readByte != 100 {
+		t.Error("Section() started at the wrong offset")
+	}
+	if mem.cursor != 0 {
+		t.Error("Section() shares its cursor with the parent")
+	}
+	cursor, _ := section.Seek(0, 2)
+	if cursor != 50 {
+		t.Error("Section() has the wrong bounds")
+	}
+	if _, err := section.Write([]byte{1}); err == nil {
+		t.Error("Section() allowed a write past its end")
+	}
+	if _, err := mem.Section(200, 57); err != ErrOutOfRange {
+		t.Error("Section() accepted a range past the end of the block")
+	}
+	section.Close()
+	if mem.isClosed() {
+		t.Error("Closing a section closed the parent")
+	}
+	section, _ = mem.Section(0, 10)
+	mem.Close()
+	if _, err := section.ReadByte(); err != ErrClosed {
+		t.Error("Closing the parent did not close the section")
+	}
+}
+
+func TestSectionFollowsGrow(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	defer mem.Close()
+	section, _ := mem.Section(100, 50)
+	for attempt := 0; attempt < 8; attempt++ {
+		mem.Grow(mem.Size * 2)
+	}
+	readByte, err := section.ReadByte()
+	if err != nil || readByte != 100 || section.Cbuf != unsafe.Add(mem.Cbuf, 100) {
+		t.Error("Section didn't follow its parent to the new block")
+	}
+	mem.Grow(120)
+	if _, err := section.ReadByte(); err != ErrClosed {
+		t.Error("Section still usable after its parent was shrunk past it")
+	}
+}
+
+func TestSectionKeepsParent(t *testing.T) {
+	mem, _ := AllocFromSlice(initTestData())
+	section, _ := mem.Section(0, 256)
+	mem = nil
+	runtime.GC()
+	runtime.GC()
+	buf := make([]byte, 256)
+	section.Read(buf)
+	for index, data := range buf {
+		if byte(index) != data {
+			t.Fatal("Section() let its parent be finalized")
+		}
+	}
+}
+
+func TestNewReaderWriter(t *testing.T) {
+	mem, _ := Alloc(256)
+	writer, _ := mem.NewWriter()
+	reader, _ := mem.NewReader()
+	writer.Write(initTestData())
+	buf := make([]byte, 256)
+	bytesRead, err := reader.Read(buf)
+	if err != nil || bytesRead != 256 {
+		t.Error("NewReader() returned a reader with the wrong size")
+	}
+	for index, data := range buf {
+		if byte(index) != data {
+			t.Error("NewReader() did not share the block with NewWriter()")
+		}
+	}
+	if _, err := reader.WriteAt([]byte{1}, 0); err != ErrReadOnly {
+		t.Error("NewReader() returned a writable Memory")
+	}
+}
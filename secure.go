@@ -0,0 +1,156 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+/*
+#define _DEFAULT_SOURCE
+#include <string.h>
+#include <sys/mman.h>
+#include <sys/types.h>
+
+void* map_pages(size_t length, int prot, int flags, int fd, off_t offset);
+*/
+import "C"
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+)
+
+var ErrNotSecure = errors.New("Memory block was not allocated with AllocSecure")
+var ErrInvalidProtection = errors.New("Protection must be ReadOnly or NoAccess")
+var ErrNoAccess = errors.New("Memory block is protected with NoAccess")
+
+// Protection is an access mode for Protect.
+type Protection int
+
+const (
+	// NoAccess makes any access to the block fault.
+	NoAccess Protection = C.PROT_NONE
+	// ReadOnly makes writes to the block fault.
+	ReadOnly Protection = C.PROT_READ
+)
+
+// secureBacking is a locked anonymous mapping that is wiped before it's
+// unmapped.
+type secureBacking struct {
+	base       unsafe.Pointer
+	length     uint64
+	protection C.int
+}
+
+// AllocSecure creates a new Memory struct for holding secrets such as keys.
+// The block gets its own pages, which are locked into RAM with mlock() so they
+// are never written to swap, and excluded from core dumps with
+// MADV_DONTDUMP. Close() and the finalizer overwrite the contents with
+// explicit_bzero() before the pages are unmapped, as does Grow() for the old
+// pages. Locking fails if it would go over RLIMIT_MEMLOCK, in which case
+// AllocSecure returns the error instead of an unlocked block.
+func AllocSecure(size uint64) (*Memory, error) {
+	newMemory := new(Memory)
+	backing, err := mapSecure(size)
+	if err != nil {
+		return newMemory, err
+	}
+	newMemory.Cbuf = backing.base
+	newMemory.Size = size
+	newMemory.capacity = size
+	newMemory.backing = backing
+	newMemory.own()
+	return newMemory, nil
+}
+
+// mapSecure maps, locks, and excludes from core dumps enough pages for size
+// bytes.
+func mapSecure(size uint64) (*secureBacking, error) {
+	pageSize := uint64(os.Getpagesize())
+	length := (size + pageSize - 1) / pageSize * pageSize
+	if length == 0 {
+		length = pageSize
+	}
+	base, err := C.map_pages(C.size_t(length), C.PROT_READ|C.PROT_WRITE, C.MAP_PRIVATE|C.MAP_ANONYMOUS, -1, 0)
+	if base == nil {
+		return nil, err
+	}
+	_, err = C.mlock(base, C.size_t(length))
+	if err == nil {
+		_, err = C.madvise(base, C.size_t(length), C.MADV_DONTDUMP)
+	}
+	if err != nil {
+		C.munmap(base, C.size_t(length))
+		return nil, err
+	}
+	return &secureBacking{base, length, C.PROT_READ | C.PROT_WRITE}, nil
+}
+
+func (this *secureBacking) resize(mem *Memory, size uint64) error {
+	newBacking, err := mapSecure(size)
+	if err != nil {
+		return err
+	}
+	// The old pages have to be readable to copy them, and writable to wipe
+	// them.
+	C.mprotect(this.base, C.size_t(this.length), C.PROT_READ|C.PROT_WRITE)
+	copySize := mem.Size
+	if size < copySize {
+		copySize = size
+	}
+	C.memcpy(newBacking.base, this.base, C.size_t(copySize))
+	if this.protection != newBacking.protection {
+		C.mprotect(newBacking.base, C.size_t(newBacking.length), this.protection)
+		newBacking.protection = this.protection
+	}
+	this.free(mem)
+	*this = *newBacking
+	mem.Cbuf = this.base
+	mem.Size = size
+	mem.capacity = size
+	return nil
+}
+
+func (this *secureBacking) free(mem *Memory) {
+	if this.base == nil {
+		return
+	}
+	C.mprotect(this.base, C.size_t(this.length), C.PROT_READ|C.PROT_WRITE)
+	C.explicit_bzero(this.base, C.size_t(this.length))
+	C.munlock(this.base, C.size_t(this.length))
+	C.munmap(this.base, C.size_t(this.length))
+	this.base = nil
+}
+
+// Protect changes the access to a block from AllocSecure to ReadOnly or
+// NoAccess with mprotect(), for the time the secret isn't being used. Go
+// writes through the Memory then return ErrReadOnly, and reads of a NoAccess
+// block return ErrNoAccess, while C code touching the block, or Go code using a
+// slice from Bytes() taken earlier, crashes with a fault.
+func (this *Memory) Protect(protection Protection) error {
+	if protection != ReadOnly && protection != NoAccess {
+		return ErrInvalidProtection
+	}
+	return this.protect(C.int(protection))
+}
+
+// Unprotect makes a block from AllocSecure readable and writable again.
+func (this *Memory) Unprotect() error {
+	return this.protect(C.PROT_READ | C.PROT_WRITE)
+}
+
+func (this *Memory) protect(protection C.int) error {
+	if this.isClosed() {
+		return ErrClosed
+	}
+	backing, ok := this.backing.(*secureBacking)
+	if !ok {
+		return ErrNotSecure
+	}
+	_, err := C.mprotect(backing.base, C.size_t(backing.length), protection)
+	if err != nil {
+		return err
+	}
+	backing.protection = protection
+	this.readOnly = protection != C.PROT_READ|C.PROT_WRITE
+	this.noAccess = protection == C.PROT_NONE
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// lockedKilobytes returns this process's locked memory in kB from
+// /proc/self/status.
+func lockedKilobytes(t *testing.T) int {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if strings.HasPrefix(line, "VmLck:") {
+			kilobytes, _ := strconv.Atoi(strings.Fields(line)[1])
+			return kilobytes
+		}
+	}
+	t.Skip("VmLck is missing from /proc/self/status")
+	return 0
+}
+
+func allocSecureTest(t *testing.T, size uint64) *Memory {
+	mem, err := AllocSecure(size)
+	if err == syscall.EPERM || err == syscall.ENOMEM {
+		t.Skip("Can't lock memory:", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mem
+}
+
+func TestAllocSecure(t *testing.T) {
+	before := lockedKilobytes(t)
+	mem := allocSecureTest(t, 100)
+	if lockedKilobytes(t) <= before {
+		t.Error("AllocSecure() didn't lock the block")
+	}
+	if uintptr(mem.Cbuf)%uintptr(os.Getpagesize()) != 0 || mem.Size != 100 {
+		t.Error("AllocSecure() didn't give the block its own pages")
+	}
+	copy(mem.Bytes(), "secret")
+	err := mem.Grow(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mem.Bytes()[:6], []byte("secret")) {
+		t.Error("Grow() didn't keep the contents")
+	}
+	mem.Close()
+	if lockedKilobytes(t) != before {
+		t.Error("Close() didn't unlock the block")
+	}
+}
+
+func TestProtect(t *testing.T) {
+	mem := allocSecureTest(t, 64)
+	defer mem.Close()
+	if err := mem.Protect(ReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if !faults(mem.Cbuf) {
+		t.Error("Protect(ReadOnly) left the block writable")
+	}
+	if err := mem.PutUint8At(0, 1); err != ErrReadOnly {
+		t.Error("PutUint8At() didn't return ErrReadOnly")
+	}
+	section, _ := mem.Section(0, 8)
+	if _, err := section.Write([]byte("hello")); err != ErrReadOnly {
+		t.Error("Writing a section of a ReadOnly block didn't return ErrReadOnly")
+	}
+	mem.Protect(NoAccess)
+	if _, err := section.Write([]byte("hello")); err != ErrReadOnly {
+		t.Error("Writing a section of a NoAccess block didn't return ErrReadOnly")
+	}
+	if _, err := mem.Read(make([]byte, 1)); err != ErrNoAccess {
+		t.Error("Read() didn't return ErrNoAccess")
+	}
+	if _, err := mem.Uint32At(0, binary.NativeEndian); err != ErrNoAccess {
+		t.Error("Uint32At() didn't return ErrNoAccess")
+	}
+	if _, err := section.ReadByte(); err != ErrNoAccess {
+		t.Error("Reading a section of a NoAccess block didn't return ErrNoAccess")
+	}
+	if mem.Bytes() != nil {
+		t.Error("Bytes() returned a NoAccess block")
+	}
+	mem.Grow(128)
+	if !faults(mem.Cbuf) {
+		t.Error("Grow() didn't keep the protection")
+	}
+	if err := mem.Unprotect(); err != nil {
+		t.Fatal(err)
+	}
+	if faults(mem.Cbuf) || faults(unsafe.Add(mem.Cbuf, 127)) {
+		t.Error("Unprotect() didn't make the block writable")
+	}
+	if _, err := section.Write([]byte("hello")); err != nil {
+		t.Error("Section still read-only after Unprotect()")
+	}
+	if err := mem.Protect(Protection(7)); err != ErrInvalidProtection {
+		t.Error("Protect() accepted an invalid protection")
+	}
+	heap, _ := Alloc(64)
+	defer heap.Close()
+	if err := heap.Protect(ReadOnly); err != ErrNotSecure {
+		t.Error("Protect() worked on a heap block")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+/*
+#define _GNU_SOURCE
+#include <fcntl.h>
+#include <stdlib.h>
+#include <sys/mman.h>
+#cgo LDFLAGS: -lrt
+*/
+import "C"
+
+import (
+	"os"
+	"unsafe"
+)
+
+// AllocShared creates a new Memory struct backed by an anonymous memfd, which
+// can be shared with other processes by passing on the descriptor from Fd(),
+// for example over a unix socket with SCM_RIGHTS or to a child process in
+// exec.Cmd.ExtraFiles. Like the descriptors from os, it is closed on exec
+// otherwise.
+func AllocShared(size uint64) (*Memory, error) {
+	name := C.CString("cmemory")
+	defer C.free(unsafe.Pointer(name))
+	fd, err := C.memfd_create(name, C.MFD_CLOEXEC)
+	if fd < 0 {
+		return nil, err
+	}
+	return newShared(os.NewFile(uintptr(fd), "memfd:cmemory"), size)
+}
+
+// AllocSharedNamed creates a new Memory struct backed by a new POSIX shared
+// memory object, which other processes can open with OpenShared. The object
+// stays around until UnlinkShared is called.
+func AllocSharedNamed(name string, size uint64) (*Memory, error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	fd, err := C.shm_open(cName, C.O_RDWR|C.O_CREAT|C.O_EXCL, 0600)
+	if fd < 0 {
+		return nil, err
+	}
+	return newShared(os.NewFile(uintptr(fd), name), size)
+}
+
+// newShared resizes file to size bytes and maps it.
+func newShared(file *os.File, size uint64) (*Memory, error) {
+	err := file.Truncate(int64(size))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	newMemory, err := mapOpenFile(file, false)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newMemory, nil
+}
+
+// OpenShared creates a new Memory struct that maps an existing POSIX shared
+// memory object. flag is os.O_RDONLY or os.O_RDWR.
+func OpenShared(name string, flag int) (*Memory, error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	fd, err := C.shm_open(cName, C.int(flag), 0)
+	if fd < 0 {
+		return nil, err
+	}
+	return MapFd(int(fd), flag)
+}
+
+// UnlinkShared removes a POSIX shared memory object. Existing mappings of it
+// stay valid.
+func UnlinkShared(name string) error {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	_, err := C.shm_unlink(cName)
+	return err
+}
+
+// MapFd creates a new Memory struct that maps all of an open file descriptor,
+// such as one received from another process. flag is os.O_RDONLY or
+// os.O_RDWR. The Memory takes ownership of fd and closes it when it is closed.
+func MapFd(fd int, flag int) (*Memory, error) {
+	file := os.NewFile(uintptr(fd), "")
+	if file == nil {
+		return nil, os.ErrInvalid
+	}
+	newMemory, err := mapOpenFile(file, flag&(os.O_WRONLY|os.O_RDWR) == 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newMemory, nil
+}
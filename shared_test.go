@@ -0,0 +1,68 @@
+// Copyright © 2014 Emily Maier
+
+package cmemory
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestAllocShared(t *testing.T) {
+	mem, err := AllocShared(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	*(*uint32)(mem.Cbuf) = 0xcafe
+	fd, err := mem.Fd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, _, _ := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+	if flags&syscall.FD_CLOEXEC == 0 {
+		t.Error("AllocShared() created a descriptor that is inherited on exec")
+	}
+	dup, _ := syscall.Dup(fd)
+	other, err := MapFd(dup, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if other.Size != 4096 {
+		t.Error("MapFd() set the wrong size")
+	}
+	if *(*uint32)(other.Cbuf) != 0xcafe {
+		t.Error("MapFd() did not share the memory")
+	}
+	*(*uint32)(other.Cbuf) = 0xbeef
+	if *(*uint32)(mem.Cbuf) != 0xbeef {
+		t.Error("AllocShared() did not share the memory")
+	}
+}
+
+func TestAllocSharedNamed(t *testing.T) {
+	name := fmt.Sprintf("/cmemory-test-%d", os.Getpid())
+	mem, err := AllocSharedNamed(name, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer UnlinkShared(name)
+	defer mem.Close()
+	*(*byte)(mem.Cbuf) = 7
+	other, err := OpenShared(name, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if *(*byte)(other.Cbuf) != 7 {
+		t.Error("OpenShared() did not share the memory")
+	}
+	if _, err := other.WriteAt([]byte{1}, 0); err != ErrReadOnly {
+		t.Error("OpenShared() did not respect the read-only flag")
+	}
+	if _, err := AllocSharedNamed(name, 64); err == nil {
+		t.Error("AllocSharedNamed() replaced an existing object")
+	}
+}